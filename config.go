@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -17,6 +18,17 @@ func loadConfig() *viper.Viper {
 	config.SetDefault("debug", false)
 	config.SetDefault("secure", true)
 	config.SetDefault("http_only", true)
+	config.SetDefault("session_keys", "")
+	config.SetDefault("session_encrypt", false)
+	config.SetDefault("deck_dir", "")
+	config.SetDefault("default_deck", "english")
+	config.SetDefault("store_dir", "")
+	config.SetDefault("room_idle_timeout", 30*time.Minute)
+	config.SetDefault("room_reap_interval", time.Minute)
+	config.SetDefault("reconnect_grace_period", 2*time.Minute)
+	config.SetDefault("vote_time", 30*time.Second)
+	config.SetDefault("failed_attempt_limit", 10)
+	config.SetDefault("failed_attempt_window", 10*time.Minute)
 
 	err := config.ReadInConfig()
 	if err != nil {
@@ -0,0 +1,148 @@
+package deck
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Longest word, in characters, that will still fit on a card.
+const MAX_WORD_LENGTH = 24
+
+// Most words a custom deck may contain.
+const MAX_DECK_SIZE = 2000
+
+// Longest name a custom deck may be given.
+const MAX_DECK_NAME_LENGTH = 48
+
+// Tag given to every deck created from a user-provided word list.
+const CUSTOM_TAG = "custom"
+
+var deckNamePattern = regexp.MustCompile(`^[A-Za-z0-9 _-]+$`)
+
+/**
+ * Parses a user-provided word list into a deck. Words may be separated by new lines or
+ * commas. Surrounding whitespace is trimmed from each word, and empty entries between
+ * separators are ignored, but words containing control characters (such as a stray tab),
+ * words that are too long, and decks that have too few unique words are all rejected.
+ */
+func ParseCustomDeck(name string, language string, text string) (*Deck, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("deck must be given a name")
+	}
+	if utf8.RuneCountInString(name) > MAX_DECK_NAME_LENGTH {
+		return nil, fmt.Errorf("deck name is longer than %d characters", MAX_DECK_NAME_LENGTH)
+	}
+	if !deckNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("deck name may only contain letters, numbers, spaces, dashes and underscores")
+	}
+
+	entries := strings.FieldsFunc(
+		text,
+		func(r rune) bool {
+			return r == '\n' || r == '\r' || r == ','
+		},
+	)
+
+	if len(entries) > MAX_DECK_SIZE {
+		return nil, fmt.Errorf("deck has %d words, at most %d are allowed", len(entries), MAX_DECK_SIZE)
+	}
+
+	words := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		word := strings.TrimSpace(entry)
+		if word == "" {
+			continue
+		}
+
+		if strings.IndexFunc(word, unicode.IsControl) != -1 {
+			return nil, fmt.Errorf("word %q contains a control character", word)
+		}
+
+		if utf8.RuneCountInString(word) > MAX_WORD_LENGTH {
+			return nil, fmt.Errorf("word %q is longer than %d characters", word, MAX_WORD_LENGTH)
+		}
+
+		words = append(words, word)
+	}
+
+	deck := &Deck{
+		Name:     name,
+		Language: strings.TrimSpace(language),
+		Tags:     []string{CUSTOM_TAG},
+		Words:    words,
+	}
+
+	deck.normalise()
+
+	if len(deck.Words) < MIN_DECK_SIZE {
+		return nil, fmt.Errorf(
+			"deck has %d unique words, needs at least %d",
+			len(deck.Words),
+			MIN_DECK_SIZE,
+		)
+	}
+
+	return deck, nil
+}
+
+/**
+ * Saves a custom deck to the library's deck directory so that it persists across
+ * restarts, and adds it to the library for use by other rooms. Saved decks are shared by
+ * all rooms, so a custom deck may not replace any deck already in the library.
+ */
+func (l *Library) Save(deck *Deck) error {
+	if l.Dir == "" {
+		return fmt.Errorf("no deck directory configured to save decks to")
+	}
+
+	data, err := json.MarshalIndent(deck, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	l.DecksMutex.Lock()
+	defer l.DecksMutex.Unlock()
+
+	if _, exists := l.Decks[deck.Name]; exists {
+		return fmt.Errorf("a deck already exists with name: %s", deck.Name)
+	}
+
+	/**
+	 * Decks are known by the name they were saved with rather than by their file's name,
+	 * so the file must be new lest it be another deck's, which would be lost on restart.
+	 */
+
+	path := filepath.Join(l.Dir, deck.Name+".json")
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("a deck file already exists with name: %s", deck.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save deck %s: %w", deck.Name, err)
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("could not save deck %s: %w", deck.Name, err)
+	}
+
+	l.Decks[deck.Name] = deck
+
+	l.Log.Info(fmt.Sprintf("saved deck: (%s, %s) with %d words", deck.Name, deck.Language, len(deck.Words)))
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package deck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+ * Makes a list of count distinct words, joined by the separator.
+ */
+func wordList(count int, separator string) string {
+	words := make([]string, count)
+	for index := range words {
+		words[index] = fmt.Sprintf("word%d", index)
+	}
+
+	return strings.Join(words, separator)
+}
+
+func TestParseCustomDeck(t *testing.T) {
+	tests := []struct {
+		name     string
+		deckName string
+		text     string
+		words    int // Number of words expected in the deck, or zero if rejected.
+	}{
+		{"new lines", "mine", wordList(MIN_DECK_SIZE, "\n"), MIN_DECK_SIZE},
+		{"commas", "mine", wordList(MIN_DECK_SIZE, ","), MIN_DECK_SIZE},
+		{"windows new lines", "mine", wordList(MIN_DECK_SIZE, "\r\n"), MIN_DECK_SIZE},
+		{"surrounding whitespace", "mine", wordList(MIN_DECK_SIZE, " ,\n  "), MIN_DECK_SIZE},
+		{"empty entries", "mine", wordList(MIN_DECK_SIZE, ",,\n\n"), MIN_DECK_SIZE},
+		{"repeated words", "mine", wordList(MIN_DECK_SIZE, "\n") + "\nWORD0\nword1", MIN_DECK_SIZE},
+		{"too few words", "mine", wordList(MIN_DECK_SIZE-1, "\n"), 0},
+		{"too few unique words", "mine", wordList(MIN_DECK_SIZE-1, "\n") + "\nword0", 0},
+		{"too many words", "mine", wordList(MAX_DECK_SIZE+1, "\n"), 0},
+		{"word too long", "mine", wordList(MIN_DECK_SIZE, "\n") + "\n" + strings.Repeat("a", MAX_WORD_LENGTH+1), 0},
+		{"longest word", "mine", wordList(MIN_DECK_SIZE, "\n") + "\n" + strings.Repeat("a", MAX_WORD_LENGTH), MIN_DECK_SIZE + 1},
+		{"control character", "mine", wordList(MIN_DECK_SIZE, "\n") + "\nbad\tword", 0},
+		{"no name", "  ", wordList(MIN_DECK_SIZE, "\n"), 0},
+		{"name too long", strings.Repeat("a", MAX_DECK_NAME_LENGTH+1), wordList(MIN_DECK_SIZE, "\n"), 0},
+		{"name with path", "../mine", wordList(MIN_DECK_SIZE, "\n"), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				deck, err := ParseCustomDeck(test.deckName, "english", test.text)
+
+				if test.words == 0 {
+					if err == nil {
+						t.Fatalf("expected deck to be rejected, got %d words", len(deck.Words))
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if len(deck.Words) != test.words {
+					t.Errorf("expected %d words, got %d", test.words, len(deck.Words))
+				}
+
+				if !deck.HasTag(CUSTOM_TAG) {
+					t.Errorf("expected deck to be tagged %s", CUSTOM_TAG)
+				}
+			},
+		)
+	}
+}
+
+func TestLibrarySave(t *testing.T) {
+	library := &Library{
+		Log:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Dir:   t.TempDir(),
+		Decks: make(map[string]*Deck),
+	}
+
+	builtIn, _ := ParseCustomDeck("built in", "english", wordList(MIN_DECK_SIZE, "\n"))
+	builtIn.Tags = nil
+	library.Add(builtIn)
+
+	first, _ := ParseCustomDeck("mine", "english", wordList(MIN_DECK_SIZE, "\n"))
+	err := library.Save(first)
+	if err != nil {
+		t.Fatalf("unexpected error saving deck: %v", err)
+	}
+
+	second, _ := ParseCustomDeck("mine", "english", wordList(MIN_DECK_SIZE+1, "\n"))
+	if library.Save(second) == nil {
+		t.Errorf("expected saving over a custom deck to be rejected")
+	}
+
+	if library.Get("mine") != first {
+		t.Errorf("expected saved custom deck to be left in place")
+	}
+
+	replacement, _ := ParseCustomDeck("built in", "english", wordList(MIN_DECK_SIZE, "\n"))
+	if library.Save(replacement) == nil {
+		t.Errorf("expected saving over a built-in deck to be rejected")
+	}
+
+	// Decks are known by their name, so another deck may be saved in a file of that name.
+	path := filepath.Join(library.Dir, "theirs.json")
+	contents := []byte(`{"name": "other"}`)
+
+	err = os.WriteFile(path, contents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clash, _ := ParseCustomDeck("theirs", "english", wordList(MIN_DECK_SIZE, "\n"))
+	if library.Save(clash) == nil {
+		t.Errorf("expected saving over another deck's file to be rejected")
+	}
+
+	if library.Get("theirs") != nil {
+		t.Errorf("expected deck whose file could not be saved not to be added")
+	}
+
+	saved, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(saved, contents) {
+		t.Errorf("expected other deck's file to be left in place")
+	}
+}
@@ -0,0 +1,75 @@
+package deck
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MatthewJM96/susnames/util"
+)
+
+type Deck struct {
+	Name     string   `json:"name"`
+	Language string   `json:"language"`
+	Tags     []string `json:"tags"`
+	Words    []string `json:"words"`
+}
+
+/**
+ * Trims whitespace from every word in the deck and drops any blank or repeated words,
+ * keeping the first occurrence of each.
+ */
+func (d *Deck) normalise() {
+	seen := make(map[string]struct{}, len(d.Words))
+	words := make([]string, 0, len(d.Words))
+
+	for _, word := range d.Words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+
+		key := strings.ToLower(word)
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		words = append(words, word)
+	}
+
+	d.Words = words
+}
+
+func (d *Deck) HasTag(tag string) bool {
+	for _, candidate := range d.Tags {
+		if candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Draws count distinct words from the deck at random. The deck itself is left untouched,
+ * so it can be drawn from again for the next game.
+ */
+func (d *Deck) Draw(count int) ([]string, error) {
+	if count > len(d.Words) {
+		return nil, fmt.Errorf(
+			"deck %s has %d words, cannot draw %d",
+			d.Name,
+			len(d.Words),
+			count,
+		)
+	}
+
+	util.RefreshRandSeed()
+
+	words := make([]string, count)
+	for i, idx := range util.Rnd.Perm(len(d.Words))[:count] {
+		words[i] = d.Words[idx]
+	}
+
+	return words, nil
+}
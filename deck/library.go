@@ -0,0 +1,149 @@
+package deck
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Minimum number of words a deck must have to be able to fill a grid.
+const MIN_DECK_SIZE = 25
+
+//go:embed decks/*.json
+var embeddedDecks embed.FS
+
+type Library struct {
+	Log *slog.Logger
+	Dir string
+
+	Decks      map[string]*Deck
+	DecksMutex sync.RWMutex
+}
+
+/**
+ * Creates a library of decks, loading first those decks embedded in the binary, and then
+ * any found in the directory specified by the "deck_dir" config option. Decks loaded from
+ * the directory take precedence over embedded decks of the same name.
+ */
+func NewLibrary(config *viper.Viper, log *slog.Logger) (*Library, error) {
+	library := &Library{
+		Log:   log,
+		Dir:   config.GetString("deck_dir"),
+		Decks: make(map[string]*Deck),
+	}
+
+	err := library.loadFS(embeddedDecks, "decks")
+	if err != nil {
+		return nil, err
+	}
+
+	if library.Dir != "" {
+		err = library.loadFS(os.DirFS(library.Dir), ".")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, exists := library.Decks[config.GetString("default_deck")]; !exists {
+		return nil, fmt.Errorf("default deck does not exist: %s", config.GetString("default_deck"))
+	}
+
+	return library, nil
+}
+
+func (l *Library) loadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("could not read deck directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("could not read deck file %s: %w", entry.Name(), err)
+		}
+
+		deck := &Deck{}
+		err = json.Unmarshal(data, deck)
+		if err != nil {
+			return fmt.Errorf("could not parse deck file %s: %w", entry.Name(), err)
+		}
+
+		err = l.Add(deck)
+		if err != nil {
+			return fmt.Errorf("could not load deck file %s: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Adds a deck to the library, replacing any existing deck of the same name.
+ */
+func (l *Library) Add(deck *Deck) error {
+	if deck.Name == "" {
+		return fmt.Errorf("deck has no name")
+	}
+
+	deck.normalise()
+
+	if len(deck.Words) < MIN_DECK_SIZE {
+		return fmt.Errorf(
+			"deck %s has %d unique words, needs at least %d",
+			deck.Name,
+			len(deck.Words),
+			MIN_DECK_SIZE,
+		)
+	}
+
+	l.DecksMutex.Lock()
+	defer l.DecksMutex.Unlock()
+
+	l.Decks[deck.Name] = deck
+
+	l.Log.Info(fmt.Sprintf("loaded deck: (%s, %s) with %d words", deck.Name, deck.Language, len(deck.Words)))
+
+	return nil
+}
+
+func (l *Library) Get(name string) *Deck {
+	l.DecksMutex.RLock()
+	defer l.DecksMutex.RUnlock()
+
+	return l.Decks[name]
+}
+
+/**
+ * Lists the decks of the library, sorted by name.
+ */
+func (l *Library) List() []*Deck {
+	l.DecksMutex.RLock()
+	defer l.DecksMutex.RUnlock()
+
+	decks := make([]*Deck, 0, len(l.Decks))
+	for _, deck := range l.Decks {
+		decks = append(decks, deck)
+	}
+
+	sort.Slice(
+		decks,
+		func(i, j int) bool {
+			return decks[i].Name < decks[j].Name
+		},
+	)
+
+	return decks
+}
@@ -3,8 +3,9 @@ package grid
 import (
 	"errors"
 	"fmt"
-	"sync"
+	"slices"
 
+	"github.com/MatthewJM96/susnames/deck"
 	"github.com/MatthewJM96/susnames/util"
 )
 
@@ -23,20 +24,23 @@ type Card struct {
 	Votes    map[string]struct{}
 }
 
+var ErrCardOutOfRange = errors.New("card index out-of-range")
+var ErrCardSelected = errors.New("card already selected")
+
 type Grid struct {
-	GridMutex sync.Mutex
-	Cards     [25]*Card
+	Cards [25]*Card
 }
 
-func CreateGrid(spyCards int, counterspyCards int) *Grid {
-	grid := &Grid{}
-
-	// TODO(Matthew): support card decks, and even custom decks.
-	// grid.assignCards()
+func CreateGrid(spyCards int, counterspyCards int, deck *deck.Deck) (*Grid, error) {
+	drawn, err := deck.Draw(25)
+	if err != nil {
+		return nil, err
+	}
 
-	grid.assignTypes(spyCards, counterspyCards)
+	var words [25]string
+	copy(words[:], drawn)
 
-	return grid
+	return CreateGridFromWords(spyCards, counterspyCards, words), nil
 }
 
 func CreateGridFromWords(spyCards int, counterspyCards int, words [25]string) *Grid {
@@ -104,18 +108,37 @@ func (g *Grid) ResetVote() {
 	}
 }
 
-func (g *Grid) VoteCardAtIndex(index int, voteID string) (bool, error) {
-	if index >= 25 {
-		return false, fmt.Errorf("card index %d out-of-range", index)
+/**
+ * Clears the votes of the cards at the given indices.
+ */
+func (g *Grid) ResetVotesAt(indices []int) {
+	for _, index := range indices {
+		g.Cards[index].Votes = make(map[string]struct{})
 	}
+}
 
-	g.GridMutex.Lock()
-	defer g.GridMutex.Unlock()
+/**
+ * Removes any votes cast by the given voter on cards that have not yet been selected.
+ */
+func (g *Grid) RemoveVotes(voteID string) {
+	for _, card := range g.Cards {
+		if card.Selected {
+			continue
+		}
+
+		delete(card.Votes, voteID)
+	}
+}
+
+func (g *Grid) VoteCardAtIndex(index int, voteID string) (bool, error) {
+	if index < 0 || index >= 25 {
+		return false, fmt.Errorf("card index %d: %w", index, ErrCardOutOfRange)
+	}
 
 	card := g.Cards[index]
 
 	if card.Selected {
-		return false, fmt.Errorf("card at index %d already selected", index)
+		return false, fmt.Errorf("card at index %d: %w", index, ErrCardSelected)
 	}
 
 	_, exists := card.Votes[voteID]
@@ -129,17 +152,14 @@ func (g *Grid) VoteCardAtIndex(index int, voteID string) (bool, error) {
 }
 
 func (g *Grid) UnvoteCardAtIndex(index int, voteID string) (bool, error) {
-	if index >= 25 {
-		return false, fmt.Errorf("card index %d out-of-range", index)
+	if index < 0 || index >= 25 {
+		return false, fmt.Errorf("card index %d: %w", index, ErrCardOutOfRange)
 	}
 
-	g.GridMutex.Lock()
-	defer g.GridMutex.Unlock()
-
 	card := g.Cards[index]
 
 	if card.Selected {
-		return false, fmt.Errorf("card at index %d already selected", index)
+		return false, fmt.Errorf("card at index %d: %w", index, ErrCardSelected)
 	}
 
 	_, exists := card.Votes[voteID]
@@ -152,21 +172,91 @@ func (g *Grid) UnvoteCardAtIndex(index int, voteID string) (bool, error) {
 	return true, nil
 }
 
-func (g *Grid) EvaluateVote() error {
+func (g *Grid) CountType(cardType CardType) int {
+	count := 0
+	for _, card := range g.Cards {
+		if card.Type == cardType {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+func (g *Grid) CountSelectedOfType(cardType CardType) int {
+	count := 0
+	for _, card := range g.Cards {
+		if card.Selected && card.Type == cardType {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+/**
+ * Gets the indices of the unselected cards with the most votes, in order of index, from
+ * among the cards at the given indices or, should none be given, every card. Should no
+ * card have received a vote, no indices are given.
+ */
+func (g *Grid) MostVoted(among ...int) []int {
 	highestVote := 0
-	highestIndex := -1
+	highestIndices := make([]int, 0)
 	for index, card := range g.Cards {
+		if card.Selected || len(card.Votes) == 0 {
+			continue
+		}
+
+		if len(among) > 0 && !slices.Contains(among, index) {
+			continue
+		}
+
 		if len(card.Votes) > highestVote {
 			highestVote = len(card.Votes)
-			highestIndex = index
+			highestIndices = highestIndices[:0]
+		}
+
+		if len(card.Votes) == highestVote {
+			highestIndices = append(highestIndices, index)
 		}
 	}
 
-	if highestIndex == -1 {
-		return errors.New("no card received a vote in voting round")
+	return highestIndices
+}
+
+/**
+ * Gets the indices of the unselected cards of the given types, or of any type should no
+ * types be given, in order of index.
+ */
+func (g *Grid) Unselected(cardTypes ...CardType) []int {
+	indices := make([]int, 0)
+	for index, card := range g.Cards {
+		if card.Selected {
+			continue
+		}
+
+		if len(cardTypes) > 0 && !slices.Contains(cardTypes, card.Type) {
+			continue
+		}
+
+		indices = append(indices, index)
+	}
+
+	return indices
+}
+
+func (g *Grid) SelectCardAtIndex(index int) error {
+	if index < 0 || index >= 25 {
+		return fmt.Errorf("card index %d: %w", index, ErrCardOutOfRange)
+	}
+
+	card := g.Cards[index]
+
+	if card.Selected {
+		return fmt.Errorf("card at index %d: %w", index, ErrCardSelected)
 	}
 
-	g.Cards[highestIndex].Selected = true
+	card.Selected = true
 
 	return nil
 }
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/deck"
+	"github.com/MatthewJM96/susnames/session"
+)
+
+// Largest request body accepted when uploading a deck, including any form overhead.
+const MAX_DECK_UPLOAD_SIZE = 1 << 20
+
+/**
+ * Accepts a custom deck for a room from its host, either as an uploaded file or as a
+ * pasted list of words. The deck is validated, kept with the room for its next games,
+ * and, if asked for, saved to the deck library for reuse by other rooms.
+ */
+func (h *Handler) UploadDeck(writer http.ResponseWriter, request *http.Request) {
+	room := h.findRoom(writer, request)
+	if room == nil {
+		return
+	}
+
+	if !room.IsHost(session.SessionID(request.Context())) {
+		components.DeckStatus("only the host of the room can change its deck", false).Render(request.Context(), writer)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, MAX_DECK_UPLOAD_SIZE)
+
+	err := request.ParseMultipartForm(MAX_DECK_UPLOAD_SIZE)
+	if err != nil && err != http.ErrNotMultipart {
+		components.DeckStatus("could not read deck upload: "+err.Error(), false).Render(request.Context(), writer)
+		return
+	}
+
+	/**
+	 * Prefer an uploaded file if one was given, otherwise use whatever was pasted.
+	 */
+
+	words := request.FormValue("words")
+
+	file, _, err := request.FormFile("file")
+	if err == nil {
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			components.DeckStatus("could not read deck file: "+err.Error(), false).Render(request.Context(), writer)
+			return
+		}
+
+		words = string(data)
+	}
+
+	/**
+	 * Validate and set the deck, saving it to the library if requested.
+	 */
+
+	customDeck, err := deck.ParseCustomDeck(request.FormValue("deck-name"), request.FormValue("language"), words)
+	if err != nil {
+		components.DeckStatus(err.Error(), false).Render(request.Context(), writer)
+		return
+	}
+
+	room.SetCustomDeck(customDeck)
+
+	message := fmt.Sprintf("using deck %s with %d words", customDeck.Name, len(customDeck.Words))
+
+	if strings.EqualFold(request.FormValue("save"), "on") {
+		err = h.Decks.Save(customDeck)
+		if err != nil {
+			h.Log.Error(err.Error())
+			components.DeckStatus(message+", but could not save it: "+err.Error(), false).Render(request.Context(), writer)
+			return
+		}
+
+		message += ", saved for reuse"
+	}
+
+	components.DeckStatus(message, true).Render(request.Context(), writer)
+}
@@ -3,17 +3,29 @@ package handler
 import (
 	"log/slog"
 
+	"github.com/MatthewJM96/susnames/deck"
+	"github.com/MatthewJM96/susnames/room"
 	"github.com/spf13/viper"
 )
 
-func NewHandler(config *viper.Viper, log *slog.Logger) *Handler {
+func NewHandler(config *viper.Viper, log *slog.Logger, decks *deck.Library, rooms *room.Registry) *Handler {
 	return &Handler{
 		Config: config,
 		Log:    log,
+		Decks:  decks,
+		Rooms:  rooms,
+		Failures: NewFailureLimiter(
+			config.GetInt("failed_attempt_limit"),
+			config.GetDuration("failed_attempt_window"),
+		),
 	}
 }
 
 type Handler struct {
 	Config *viper.Viper
 	Log    *slog.Logger
+	Decks  *deck.Library
+	Rooms  *room.Registry
+
+	Failures *FailureLimiter
 }
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type failedAttempts struct {
+	count int
+	since time.Time
+}
+
+/**
+ * Limits how many failed attempts to find or get into a room a client may make within a
+ * window of time, so that room names and passcodes can't be brute-forced. Clients are
+ * told apart by their IP address.
+ */
+type FailureLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	Attempts      map[string]*failedAttempts
+	AttemptsMutex sync.Mutex
+}
+
+func NewFailureLimiter(limit int, window time.Duration) *FailureLimiter {
+	return &FailureLimiter{
+		Limit:    limit,
+		Window:   window,
+		Attempts: make(map[string]*failedAttempts),
+	}
+}
+
+func clientKey(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+/**
+ * Reports whether the client may make another attempt.
+ */
+func (l *FailureLimiter) Allow(request *http.Request) bool {
+	l.AttemptsMutex.Lock()
+	defer l.AttemptsMutex.Unlock()
+
+	key := clientKey(request)
+
+	attempts, exists := l.Attempts[key]
+	if !exists {
+		return true
+	}
+
+	if time.Since(attempts.since) >= l.Window {
+		delete(l.Attempts, key)
+		return true
+	}
+
+	return attempts.count < l.Limit
+}
+
+/**
+ * Records a failed attempt by the client.
+ */
+func (l *FailureLimiter) Fail(request *http.Request) {
+	l.AttemptsMutex.Lock()
+	defer l.AttemptsMutex.Unlock()
+
+	key := clientKey(request)
+
+	attempts, exists := l.Attempts[key]
+	if !exists || time.Since(attempts.since) >= l.Window {
+		l.sweep()
+
+		attempts = &failedAttempts{0, time.Now()}
+		l.Attempts[key] = attempts
+	}
+
+	attempts.count += 1
+}
+
+/**
+ * Forgets any clients whose window has passed. Must be called with the mutex held.
+ */
+func (l *FailureLimiter) sweep() {
+	for key, attempts := range l.Attempts {
+		if time.Since(attempts.since) >= l.Window {
+			delete(l.Attempts, key)
+		}
+	}
+}
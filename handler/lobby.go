@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/room"
+)
+
+// Least time between updates sent to those browsing public rooms.
+const LOBBY_UPDATE_INTERVAL = 500 * time.Millisecond
+
+func (h *Handler) publicRooms() []components.RoomListingView {
+	listings := h.Rooms.PublicRooms()
+
+	views := make([]components.RoomListingView, 0, len(listings))
+	for _, listing := range listings {
+		counterspies := "auto"
+		if listing.Counterspies != room.AUTO {
+			counterspies = strconv.Itoa(listing.Counterspies)
+		}
+
+		views = append(
+			views,
+			components.RoomListingView{
+				Name:            listing.Name,
+				Players:         listing.Players,
+				Status:          string(listing.Status),
+				Access:          listing.Access,
+				Deck:            listing.Deck,
+				Counterspies:    counterspies,
+				SpyCards:        listing.SpyCards,
+				CounterspyCards: listing.CounterspyCards,
+				VoteTime:        listing.VoteTime,
+			},
+		)
+	}
+
+	return views
+}
+
+func (h *Handler) Lobby(writer http.ResponseWriter, request *http.Request) {
+	components.Page(components.Lobby(h.publicRooms())).Render(request.Context(), writer)
+}
+
+/**
+ * Streams the list of public rooms to those browsing them as server-sent events, sending
+ * the list anew whenever a public room changes.
+ */
+func (h *Handler) LobbyEvents(writer http.ResponseWriter, request *http.Request) {
+	// The stream outlives the server's write timeout, which is meant for ordinary requests.
+	controller := http.NewResponseController(writer)
+	controller.SetWriteDeadline(time.Time{})
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+
+	changes, stop := h.Rooms.Listen()
+	defer stop()
+
+	for {
+		buf := new(bytes.Buffer)
+
+		components.RoomList(h.publicRooms()).Render(request.Context(), buf)
+
+		fmt.Fprint(writer, "event: rooms\n")
+		for _, line := range strings.Split(buf.String(), "\n") {
+			fmt.Fprintf(writer, "data: %s\n", line)
+		}
+		fmt.Fprint(writer, "\n")
+
+		err := controller.Flush()
+		if err != nil {
+			h.Log.Error(err.Error())
+			return
+		}
+
+		select {
+		case <-changes:
+		case <-request.Context().Done():
+			return
+		}
+
+		select {
+		case <-time.After(LOBBY_UPDATE_INTERVAL):
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/session"
+)
+
+/**
+ * Shows a finished game of a room as it stood after a given step of its log, or, if asked
+ * for JSON, gives the full log so that clients may step through it themselves. Only those
+ * allowed into the room may see its games.
+ */
+func (h *Handler) ReplayGame(writer http.ResponseWriter, request *http.Request) {
+	room := h.findRoom(writer, request)
+	if room == nil {
+		return
+	}
+
+	if !room.IsAdmitted(session.SessionID(request.Context())) {
+		h.Failures.Fail(request)
+
+		http.Error(writer, fmt.Sprintf("not allowed into room: %s", room.Name), http.StatusForbidden)
+		return
+	}
+
+	gameID, err := strconv.Atoi(request.PathValue("game"))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("invalid game: %s", request.PathValue("game")), http.StatusBadRequest)
+		return
+	}
+
+	game, err := room.FinishedGame(gameID)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if request.URL.Query().Get("format") == "json" {
+		writer.Header().Set("Content-Type", "application/json")
+
+		err = json.NewEncoder(writer).Encode(game)
+		if err != nil {
+			h.Log.Error(err.Error())
+		}
+		return
+	}
+
+	step := 0
+	if value := request.URL.Query().Get("step"); value != "" {
+		step, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("invalid step: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	view := components.Replay(game.View(room.Name, step))
+
+	// Stepping through the replay swaps in just the replay itself.
+	if request.Header.Get("HX-Request") != "true" {
+		view = components.Page(view)
+	}
+
+	view.Render(request.Context(), writer)
+}
@@ -1,15 +1,17 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/MatthewJM96/susnames/components"
 	"github.com/MatthewJM96/susnames/room"
+	"github.com/MatthewJM96/susnames/session"
 )
 
 func (h *Handler) CreateRoom(writer http.ResponseWriter, request *http.Request) {
-	room, err := room.CreateRoom(h.Config, h.Log)
+	room, err := h.Rooms.CreateRoom(session.SessionID(request.Context()))
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
@@ -20,16 +22,53 @@ func (h *Handler) CreateRoom(writer http.ResponseWriter, request *http.Request)
 	components.Room(room.Name).Render(request.Context(), writer)
 }
 
-func (h *Handler) JoinRoom(writer http.ResponseWriter, request *http.Request) {
+/**
+ * Finds the room named in the request's path, rejecting clients who have failed to find
+ * or get into rooms too many times of late.
+ */
+func (h *Handler) findRoom(writer http.ResponseWriter, request *http.Request) *room.Room {
+	if !h.Failures.Allow(request) {
+		http.Error(writer, "too many failed attempts, try again later", http.StatusTooManyRequests)
+		return nil
+	}
+
 	roomName := request.PathValue("name")
 
-	room := room.GetRoom(roomName)
+	room := h.Rooms.GetRoom(roomName)
 	if room == nil {
+		h.Failures.Fail(request)
+
 		http.Error(writer, fmt.Sprintf("no room exists with name: %s", roomName), http.StatusBadRequest)
+		return nil
+	}
+
+	return room
+}
+
+func (h *Handler) JoinRoom(writer http.ResponseWriter, request *http.Request) {
+	target := h.findRoom(writer, request)
+	if target == nil {
 		return
 	}
 
-	view := components.Room(room.Name)
+	view := components.Room(target.Name)
+
+	/**
+	 * Private rooms ask for a passcode of those who weren't given an invite.
+	 */
+
+	err := target.Admit(
+		session.SessionID(request.Context()),
+		request.PostFormValue("passcode"),
+		request.URL.Query().Get("invite"),
+	)
+	if errors.Is(err, room.ErrAccessRequired) {
+		view = components.RoomLocked(target.Name, "")
+	} else if err != nil {
+		h.Failures.Fail(request)
+
+		view = components.RoomLocked(target.Name, "That passcode or invite isn't valid.")
+	}
 
 	if request.Method == http.MethodGet {
 		view = components.Page(view)
@@ -42,11 +81,15 @@ func (h *Handler) JoinRoom(writer http.ResponseWriter, request *http.Request) {
 }
 
 func (h *Handler) ConnectPlayerToRoom(writer http.ResponseWriter, request *http.Request) {
-	roomName := request.PathValue("name")
-
-	room := room.GetRoom(roomName)
+	room := h.findRoom(writer, request)
 	if room == nil {
-		http.Error(writer, fmt.Sprintf("no room exists with name: %s", roomName), http.StatusBadRequest)
+		return
+	}
+
+	if !room.IsAdmitted(session.SessionID(request.Context())) {
+		h.Failures.Fail(request)
+
+		http.Error(writer, fmt.Sprintf("not allowed into room: %s", room.Name), http.StatusForbidden)
 		return
 	}
 
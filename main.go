@@ -1,39 +1,110 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/MatthewJM96/susnames/deck"
 	"github.com/MatthewJM96/susnames/handler"
+	"github.com/MatthewJM96/susnames/room"
 	"github.com/MatthewJM96/susnames/session"
 )
 
+// Time given to requests under way to finish once the server is asked to stop.
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 func main() {
 	config := loadConfig()
 
 	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 
-	handlers := handler.NewHandler(config, log)
+	decks, err := deck.NewLibrary(config, log)
+	if err != nil {
+		panic(fmt.Errorf("fatal error loading decks: %w", err))
+	}
+
+	cookies, err := session.NewCookieCodec(config, log)
+	if err != nil {
+		panic(fmt.Errorf("fatal error creating cookie codec: %w", err))
+	}
+
+	var store room.Store
+	if config.GetString("store_dir") != "" {
+		store, err = room.NewFileStore(config.GetString("store_dir"))
+		if err != nil {
+			panic(fmt.Errorf("fatal error creating room store: %w", err))
+		}
+	} else {
+		log.Warn("no room store directory configured, rooms will not survive a restart")
+	}
+
+	rooms := room.NewRegistry(config, log, decks, cookies, store)
+
+	err = rooms.Restore()
+	if err != nil {
+		panic(fmt.Errorf("fatal error restoring rooms: %w", err))
+	}
+
+	go rooms.Reap()
+
+	handlers := handler.NewHandler(config, log, decks, rooms)
 
 	router := http.NewServeMux()
 	router.HandleFunc("GET /", handlers.Home)
 	router.HandleFunc("POST /create-room", handlers.CreateRoom)
+	router.HandleFunc("GET /rooms", handlers.Lobby)
+	router.HandleFunc("GET /rooms/events", handlers.LobbyEvents)
 	router.HandleFunc("GET /room/{name}", handlers.JoinRoom)
 	router.HandleFunc("POST /room/{name}", handlers.JoinRoom)
 	router.HandleFunc("GET /room/{name}/conn", handlers.ConnectPlayerToRoom)
+	router.HandleFunc("POST /room/{name}/deck", handlers.UploadDeck)
+	router.HandleFunc("GET /room/{name}/replay/{game}", handlers.ReplayGame)
+
+	session := session.NewSessionMiddleware(router, config, log, cookies)
 
-	session := session.NewSessionMiddleware(router, config)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	server := &http.Server{
 		Addr:         "localhost:9000",
 		Handler:      session,
 		ReadTimeout:  time.Second * 10,
 		WriteTimeout: time.Second * 10,
+		// Requests are cancelled on stopping, so that streams of events don't hold it up.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		fmt.Printf("Listening on %v\n", server.Addr)
+
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(fmt.Sprintf("server stopped: %s", err.Error()))
+		}
+
+		stop()
+	}()
+
+	<-ctx.Done()
+
+	log.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Error(fmt.Sprintf("could not shut down server cleanly: %s", err.Error()))
 	}
 
-	fmt.Printf("Listening on %v\n", server.Addr)
-	server.ListenAndServe()
+	// Rooms are closed only once no more requests can reach them, saving each as they go.
+	rooms.Close()
 }
@@ -0,0 +1,259 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/MatthewJM96/susnames/components"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type accessMode string
+
+const (
+	ACCESS_OPEN     accessMode = "open"     // Anyone who knows the room's name may join.
+	ACCESS_PASSCODE accessMode = "passcode" // Players must give the passcode, or an invite.
+	ACCESS_INVITE   accessMode = "invite"   // Players must have been invited.
+)
+
+// Longest passcode, in characters, that a host may set for their room.
+const MAX_PASSCODE_LENGTH = 64
+
+// Longest passcode, in bytes, that may be hashed - longer passcodes are cut short by bcrypt.
+const MAX_PASSCODE_BYTES = 72
+
+// How long an invite to a room remains valid for after being issued.
+const INVITE_LIFETIME = 24 * time.Hour
+
+var ErrAccessRequired = errors.New("room requires a passcode or invite to join")
+var ErrAccessDenied = errors.New("passcode or invite is not valid for room")
+
+/**
+ * Hashes the passcode with a salted hash that is slow to compute by design, so that any
+ * hash kept in a room's snapshot is costly to reverse.
+ */
+func hashPasscode(passcode string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+}
+
+/**
+ * Reports whether the session may join the room without giving a passcode or invite.
+ * Players already in the room, and the host, need not prove themselves again.
+ */
+func (r *Room) isAdmitted(sessionID string) bool {
+	if r.Access == ACCESS_OPEN || r.Host == sessionID {
+		return true
+	}
+
+	_, inRoom := r.Players[sessionID]
+	_, admitted := r.Admitted[sessionID]
+
+	return inRoom || admitted
+}
+
+/**
+ * Admits the session to the room should it give a valid invite, or, where the room takes
+ * one, its passcode. Sessions that have already been admitted are admitted without either.
+ * ErrAccessRequired is returned if neither is given, and ErrAccessDenied if those given
+ * are not valid.
+ */
+func (r *Room) Admit(sessionID string, passcode string, invite string) error {
+	var err error
+	var passcodeHash []byte
+	epoch := 0
+
+	r.call(
+		func() {
+			if r.isAdmitted(sessionID) {
+				return
+			}
+
+			if passcode == "" && invite == "" {
+				err = ErrAccessRequired
+				return
+			}
+
+			if invite != "" && r.validInvite(invite) {
+				r.admit(sessionID)
+				return
+			}
+
+			if passcode != "" && r.Access == ACCESS_PASSCODE && r.Passcode != nil {
+				passcodeHash = r.Passcode
+				epoch = r.AccessEpoch
+				return
+			}
+
+			r.Log.Warn(fmt.Sprintf("refused %s entry to room %s", sessionID, r.Name))
+
+			err = ErrAccessDenied
+		},
+	)
+
+	if err != nil || passcodeHash == nil {
+		return err
+	}
+
+	/**
+	 * The passcode is checked outside of the room's event loop, as checking it is slow by
+	 * design. Should the room's access change meanwhile, the passcode is checked no more.
+	 */
+
+	matches := bcrypt.CompareHashAndPassword(passcodeHash, []byte(passcode)) == nil
+
+	r.call(
+		func() {
+			if matches && r.AccessEpoch == epoch {
+				r.admit(sessionID)
+				return
+			}
+
+			r.Log.Warn(fmt.Sprintf("refused %s entry to room %s", sessionID, r.Name))
+
+			err = ErrAccessDenied
+		},
+	)
+
+	return err
+}
+
+func (r *Room) admit(sessionID string) {
+	r.Admitted[sessionID] = struct{}{}
+
+	r.Log.Info(fmt.Sprintf("admitted %s to room %s", sessionID, r.Name))
+}
+
+/**
+ * Reports whether the session may connect to the room without first being admitted.
+ */
+func (r *Room) IsAdmitted(sessionID string) bool {
+	admitted := false
+
+	r.call(
+		func() {
+			admitted = r.isAdmitted(sessionID)
+		},
+	)
+
+	return admitted
+}
+
+/**
+ * Gets what is signed by invites to the room. Invites are bound to the room's access
+ * epoch, so that those already handed out are revoked by any change in access.
+ */
+func (r *Room) inviteSubject() string {
+	return r.Name + "#" + strconv.Itoa(r.AccessEpoch)
+}
+
+func (r *Room) inviteToken() (string, error) {
+	return r.Cookies.Encode("SN-Invite", r.inviteSubject())
+}
+
+func (r *Room) validInvite(invite string) bool {
+	subject, issued, err := r.Cookies.Decode("SN-Invite", invite)
+	if err != nil {
+		return false
+	}
+
+	return subject == r.inviteSubject() && time.Since(issued) < INVITE_LIFETIME
+}
+
+func (r *Room) updateAccess(mode accessMode, passcode string, public bool, conn *connectionManager) {
+	if r.Host != conn.SessionID {
+		r.reject(conn, REJECT_NOT_HOST, "tried to change room access but is not the host")
+		return
+	}
+
+	if public && mode == ACCESS_INVITE {
+		r.rejectWithMessage(conn, REJECT_INVALID_ACCESS, "tried to list an invite only room publicly", "Invite only rooms can't be listed publicly.")
+		return
+	}
+
+	if mode == ACCESS_PASSCODE && passcode == "" && r.Passcode == nil {
+		r.reject(conn, REJECT_INVALID_ACCESS, "tried to require a passcode without giving one")
+		return
+	}
+
+	if passcode != "" {
+		hash, err := hashPasscode(passcode)
+		if err != nil {
+			r.reject(conn, REJECT_INVALID_ACCESS, fmt.Sprintf("tried to set a passcode that could not be hashed: %s", err.Error()))
+			return
+		}
+
+		r.Passcode = hash
+	}
+
+	// Invites, and passcodes being checked, are no longer valid once access changes.
+	if mode != r.Access || passcode != "" {
+		r.AccessEpoch += 1
+	}
+
+	r.Access = mode
+	r.Public = public
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) set access of room %s to %s, public: %t", conn.SessionID, conn.Player.Name, r.Name, mode, public))
+
+	r.broadcastAccess(context.Background())
+}
+
+/**
+ * Builds the link with which the host may invite players to the room, should the room
+ * be open only to some.
+ */
+func (r *Room) inviteLink() string {
+	if r.Access == ACCESS_OPEN {
+		return ""
+	}
+
+	token, err := r.inviteToken()
+	if err != nil {
+		r.Log.Error(err.Error())
+		return ""
+	}
+
+	return "/room/" + r.Name + "?invite=" + url.QueryEscape(token)
+}
+
+func (r *Room) makeAccess(ctx context.Context, player *Player, format messageFormat) ([]byte, bool) {
+	if r.Host != player.SessionID {
+		if format == FORMAT_JSON {
+			return nil, true
+		}
+
+		buf := new(bytes.Buffer)
+
+		components.EmptyAccess().Render(ctx, buf)
+
+		return buf.Bytes(), false
+	}
+
+	if format == FORMAT_JSON {
+		return r.marshalJSONMessage("access", "", jsonAccess{string(r.Access), r.Public, r.Passcode != nil, r.inviteLink()}), false
+	}
+
+	buf := new(bytes.Buffer)
+
+	components.Access(string(r.Access), r.Public, r.Passcode != nil, r.inviteLink()).Render(ctx, buf)
+
+	return buf.Bytes(), false
+}
+
+/**
+ * Sends the room's access controls to its host, clearing them for everyone else.
+ */
+func (r *Room) broadcastAccess(ctx context.Context) {
+	r.changed()
+
+	r.broadcastMessage(
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			return r.makeAccess(ctx, player, format)
+		},
+	)
+}
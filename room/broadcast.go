@@ -3,96 +3,317 @@ package room
 import (
 	"bytes"
 	"context"
+	"slices"
+	"sort"
+	"strconv"
 
 	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/grid"
 	"github.com/a-h/templ"
 )
 
-func (r *Room) broadcastMessage(messageFunc func(*Player) ([]byte, bool)) {
-	r.PlayersMutex.Lock()
-	defer r.PlayersMutex.Unlock()
-
+/**
+ * Broadcasts a message to every connection of every connected player. The message is
+ * built for each player in each format their connections speak, at most once per format.
+ */
+func (r *Room) broadcastMessage(messageFunc func(*Player, messageFormat) ([]byte, bool)) {
 	for _, player := range r.Players {
-		message, skip := messageFunc(player)
-
-		if skip {
+		if !player.Connected() {
 			continue
 		}
 
-		select {
-		case player.Msgs <- message:
-		default:
-			go player.CloseConn()
+		r.broadcastMessageToPlayer(messageFunc, player)
+	}
+}
+
+func (r *Room) broadcastMessageToPlayer(messageFunc func(*Player, messageFormat) ([]byte, bool), player *Player) {
+	messages := make(map[messageFormat][]byte)
+
+	for conn := range player.Conns {
+		message, built := messages[conn.Format]
+		if !built {
+			var skip bool
+
+			message, skip = messageFunc(player, conn.Format)
+			if skip {
+				message = nil
+			}
+
+			messages[conn.Format] = message
+		}
+
+		if message != nil {
+			conn.send(message)
 		}
 	}
 }
 
-func (r *Room) broadcastMessageToPlayer(message []byte, player *Player) {
-	select {
-	case player.Msgs <- message:
-	default:
-		go player.CloseConn()
+/**
+ * Gets the role of a player as it may be seen by other players. Once a game is over,
+ * everyone gets to see who the counterspies were, as they do of players ejected in a
+ * meeting.
+ */
+func (r *Room) visibleRoleClass(player *Player) string {
+	if r.Finished || player.Ejected {
+		return getPlayerRoleClass(player.Role)
 	}
+
+	return getPublicPlayerRoleClass(player.Role)
 }
 
-func (r *Room) broadcastPlayerList(ctx context.Context) {
-	r.broadcastMessage(
-		func(player *Player) ([]byte, bool) {
-			buf := new(bytes.Buffer)
+/**
+ * Gets the role a player has claimed for the next game, should it differ from the role
+ * they are shown to have. Claims aren't shown while a game is in progress, nor are claims
+ * to be one of the spies as that is where every player starts.
+ */
+func (r *Room) visibleClaimClass(player *Player) string {
+	if r.inProgress() || player.ClaimedRole == player.Role || player.ClaimedRole == SPY {
+		return ""
+	}
 
-			tags := make([]templ.Component, 0, len(r.Players))
+	return getPlayerRoleClass(player.ClaimedRole)
+}
 
-			tags = append(tags, components.PlayerNameTag(player.Name, getPlayerRoleClass(player.Role)))
+/**
+ * Gets the type of a card as it may be seen by the player. The spymaster holds the key to
+ * every card, and counterspies know which cards are theirs. Everyone else sees the type of
+ * a card only once it is revealed, or once the game is over.
+ */
+func (r *Room) visibleCardTypeClass(player *Player, card *grid.Card) string {
+	if card.Selected || r.Finished || player.Role == SPYMASTER {
+		return getCardTypeClass(card.Type)
+	}
 
-			for _, targetPlayer := range r.Players {
-				if player == targetPlayer {
-					continue
-				}
+	if player.Role == COUNTERSPY && card.Type == grid.COUNTERSPY_TARGET {
+		return getCardTypeClass(card.Type)
+	}
 
-				tags = append(tags, components.PlayerNameTag(targetPlayer.Name, getPublicPlayerRoleClass(targetPlayer.Role)))
-			}
+	return ""
+}
 
-			components.PlayerList(tags).Render(ctx, buf)
+/**
+ * Gets the names of the players who have voted for a card, in order of name.
+ */
+func (r *Room) voterNames(card *grid.Card) []string {
+	voters := make([]string, 0, len(card.Votes))
+	for sessionID := range card.Votes {
+		if voter, exists := r.Players[sessionID]; exists {
+			voters = append(voters, voter.Name)
+		}
+	}
 
-			return buf.Bytes(), false
+	sort.Strings(voters)
+
+	return voters
+}
+
+/**
+ * Reports whether the player may vote for, or unvote, cards right now.
+ */
+func (r *Room) canVote(player *Player) bool {
+	if !r.inProgress() || r.Turn != SPY || r.awaitingTieBreak() || r.Meeting != nil {
+		return false
+	}
+
+	return (player.Role == SPY || player.Role == COUNTERSPY) && !player.Ejected
+}
+
+/**
+ * Gets the command the player sends on clicking a card, if any. Spies vote for and unvote
+ * cards, only those tied in a runoff, and the spymaster breaks ties between cards.
+ */
+func (r *Room) cardAction(player *Player, index int, voted bool) string {
+	card := r.Grid.Cards[index]
+	tied := slices.Contains(r.TiedCards, index)
+
+	if card.Selected {
+		return ""
+	}
+
+	if r.canVote(player) && (r.TiedCards == nil || tied) {
+		if voted {
+			return "unvote-card"
+		}
+
+		return "vote-card"
+	}
+
+	if r.awaitingTieBreak() && r.Meeting == nil && player.Role == SPYMASTER && tied {
+		return "break-tie"
+	}
+
+	return ""
+}
+
+func (r *Room) makeCards(player *Player) []components.CardView {
+	cards := make([]components.CardView, 0, 25)
+
+	for index, card := range r.Grid.Cards {
+		_, voted := card.Votes[player.SessionID]
+
+		view := components.CardView{
+			Index:    index,
+			Word:     card.Word,
+			Type:     r.visibleCardTypeClass(player, card),
+			Selected: card.Selected,
+			Tied:     slices.Contains(r.TiedCards, index),
+			Action:   r.cardAction(player, index, voted),
+		}
+
+		// Votes are only of interest while they are being cast.
+		if r.inProgress() && r.Turn == SPY && !card.Selected {
+			view.Votes = len(card.Votes)
+			view.Voters = r.voterNames(card)
+			view.Voted = voted
+		}
+
+		cards = append(cards, view)
+	}
+
+	return cards
+}
+
+func (r *Room) makePlayerList(ctx context.Context, player *Player) []byte {
+	buf := new(bytes.Buffer)
+
+	tags := make([]templ.Component, 0, len(r.Players))
+
+	tags = append(
+		tags,
+		components.PlayerNameTag(
+			components.PlayerTagView{
+				ID:        strconv.Itoa(player.ID),
+				Name:      player.Name,
+				Role:      getPlayerRoleClass(player.Role),
+				Claim:     r.visibleClaimClass(player),
+				Connected: true,
+				Host:      r.Host == player.SessionID,
+				Ejected:   player.Ejected,
+			},
+		),
+	)
+
+	for _, targetPlayer := range r.Players {
+		if player == targetPlayer {
+			continue
+		}
+
+		tags = append(
+			tags,
+			components.PlayerNameTag(
+				components.PlayerTagView{
+					ID:        strconv.Itoa(targetPlayer.ID),
+					Name:      targetPlayer.Name,
+					Role:      r.visibleRoleClass(targetPlayer),
+					Claim:     r.visibleClaimClass(targetPlayer),
+					Connected: targetPlayer.Connected(),
+					Host:      r.Host == targetPlayer.SessionID,
+					Ejected:   targetPlayer.Ejected,
+					Controls:  r.Host == player.SessionID,
+				},
+			),
+		)
+	}
+
+	components.PlayerList(tags).Render(ctx, buf)
+	components.RoleChooser(getPlayerRoleClass(player.ClaimedRole), r.inProgress()).Render(ctx, buf)
+
+	return buf.Bytes()
+}
+
+func (r *Room) broadcastPlayerList(ctx context.Context) {
+	// Whenever players or their roles change, so may the room's listing.
+	r.changed()
+
+	r.broadcastMessage(
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			if format == FORMAT_JSON {
+				return r.makePlayerListJSON(player), false
+			}
+
+			return r.makePlayerList(ctx, player), false
 		},
 	)
 }
 
-func (r *Room) makeGameState(ctx context.Context, player *Player) []byte {
+func (r *Room) counterspyNames() []string {
+	counterspies := make([]string, 0, max(r.Counterspies, 0))
+	for _, player := range r.Players {
+		if player.Role == COUNTERSPY {
+			counterspies = append(counterspies, player.Name)
+		}
+	}
+
+	return counterspies
+}
+
+func (r *Room) makeResults(ctx context.Context) []byte {
 	buf := new(bytes.Buffer)
 
-	components.Grid(r.Grid).Render(ctx, buf)
+	components.Results(
+		getPlayerRoleClass(r.Winner),
+		r.Grid.CountType(grid.SPY_TARGET),
+		r.Grid.CountSelectedOfType(grid.SPY_TARGET),
+		r.Grid.CountType(grid.COUNTERSPY_TARGET),
+		r.Grid.CountSelectedOfType(grid.COUNTERSPY_TARGET),
+		r.counterspyNames(),
+		r.ejectedNames(),
+		r.makeScoreViews(),
+		r.replayURL(),
+	).Render(ctx, buf)
+
+	return buf.Bytes()
+}
+
+func (r *Room) makeClue(ctx context.Context, player *Player) []byte {
+	buf := new(bytes.Buffer)
+
+	notice := ""
+	if r.awaitingTieBreak() {
+		notice = "The vote tied! The spymaster is choosing between the tied cards."
+	} else if r.TiedCards != nil {
+		notice = "The vote tied! Vote again between the tied cards."
+	}
+
+	components.Clue(r.Clue, r.ClueMatches, r.canVote(player), notice).Render(ctx, buf)
+
+	return buf.Bytes()
+}
+
+func (r *Room) makeGameState(ctx context.Context, player *Player, format messageFormat) []byte {
+	if format == FORMAT_JSON {
+		return r.makeGameStateJSON(player)
+	}
+
+	buf := new(bytes.Buffer)
+
+	components.Grid(r.makeCards(player)).Render(ctx, buf)
 	components.EmptyGameControl().Render(ctx, buf)
+	buf.Write(r.makeMeeting(ctx, player))
 
-	if r.Turn == SPYMASTER {
+	if r.Finished {
+		buf.Write(r.makeResults(ctx))
+	} else if r.Turn == SPYMASTER {
 		if player.Role == SPYMASTER {
 			components.ClueSuggestor().Render(ctx, buf)
 		} else {
 			components.EmptySpymasterSuggestion().Render(ctx, buf)
 		}
 	} else if r.Turn == SPY {
-		if player.Role == SPY || player.Role == COUNTERSPY {
-			components.Clue(r.Clue, r.ClueMatches, true).Render(ctx, buf)
-		} else {
-			components.Clue(r.Clue, r.ClueMatches, false).Render(ctx, buf)
-		}
+		buf.Write(r.makeClue(ctx, player))
 	}
 
 	return buf.Bytes()
 }
 
 func (r *Room) broadcastGameState(ctx context.Context) {
-	r.GameStateMutex.Lock()
-	defer r.GameStateMutex.Unlock()
-
 	if !r.Started {
 		return
 	}
 
 	r.broadcastMessage(
-		func(player *Player) ([]byte, bool) {
-			return r.makeGameState(ctx, player), false
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			return r.makeGameState(ctx, player, format), false
 		},
 	)
 
@@ -100,63 +321,53 @@ func (r *Room) broadcastGameState(ctx context.Context) {
 }
 
 func (r *Room) broadcastClue(ctx context.Context) {
-	r.GameStateMutex.Lock()
-	defer r.GameStateMutex.Unlock()
-
-	if !r.Started || r.Turn != SPY {
+	if !r.Started || r.Finished || r.Turn != SPY {
 		return
 	}
 
 	r.broadcastMessage(
-		func(p *Player) ([]byte, bool) {
-			buf := new(bytes.Buffer)
-
-			if p.Role == SPY || p.Role == COUNTERSPY {
-				components.Clue(r.Clue, r.ClueMatches, true).Render(ctx, buf)
-			} else {
-				components.Clue(r.Clue, r.ClueMatches, false).Render(ctx, buf)
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			if format == FORMAT_JSON {
+				return r.marshalJSONMessage("clue", "", r.makeClueJSON(player)), false
 			}
 
-			return buf.Bytes(), false
+			return r.makeClue(ctx, player), false
 		},
 	)
 
+	// Cards may be voted for once a clue is given.
+	r.broadcastCards(ctx)
 	r.broadcastPlayerList(ctx)
 }
 
-func (r *Room) broadcastClueSuggestor(ctx context.Context) {
-	r.GameStateMutex.Lock()
-	defer r.GameStateMutex.Unlock()
-
-	if !r.Started || r.Turn != SPYMASTER {
+/**
+ * Broadcasts the cards of the grid, as they are seen by each player, such as when votes
+ * for them change.
+ */
+func (r *Room) broadcastCards(ctx context.Context) {
+	if !r.Started {
 		return
 	}
 
 	r.broadcastMessage(
-		func(player *Player) ([]byte, bool) {
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			if format == FORMAT_JSON {
+				return r.marshalJSONMessage("cards", "", r.makeCardsJSON(player)), false
+			}
 
 			buf := new(bytes.Buffer)
 
-			if player.Role != SPYMASTER {
-				components.EmptySpymasterSuggestion().Render(ctx, buf)
-			} else {
-				components.ClueSuggestor().Render(ctx, buf)
-			}
+			components.Grid(r.makeCards(player)).Render(ctx, buf)
 
 			return buf.Bytes(), false
 		},
 	)
-
-	r.broadcastPlayerList(ctx)
 }
 
-func (r *Room) broadcastGameStateToPlayer(ctx context.Context, player *Player) {
-	r.GameStateMutex.Lock()
-	defer r.GameStateMutex.Unlock()
-
+func (r *Room) broadcastGameStateToConnection(ctx context.Context, conn *connectionManager) {
 	if !r.Started {
 		return
 	}
 
-	r.broadcastMessageToPlayer(r.makeGameState(ctx, player), player)
+	conn.send(r.makeGameState(ctx, conn.Player, conn.Format))
 }
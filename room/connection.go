@@ -16,27 +16,33 @@ const PONG_PERIOD = PING_PERIOD + PONG_WAIT
 const WRITE_WAIT = 10 * time.Second
 const MAX_MESSAGE_BATCH = 5
 
-var upgrader = websocket.Upgrader{}
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{JSON_SUBPROTOCOL},
+}
 
 type connectionManager struct {
 	Config *viper.Viper
 	Log    *slog.Logger
 
-	Conn   *websocket.Conn
-	Room   *Room
-	Player *Player
-}
+	Conn      *websocket.Conn
+	SessionID string
+	Room      *Room
+	Player    *Player
+
+	Format messageFormat
+	Msgs   chan []byte
+	done   chan struct{}
 
-type command struct {
-	Cmd   string `json:"cmd"`
-	Data0 string `json:"data0"`
-	Data1 string `json:"data1"`
+	// State of the command currently being processed from this connection.
+	RequestID string
+	Rejected  bool
 }
 
 func newConnectionManager(
 	config *viper.Viper,
 	log *slog.Logger,
 	connection *websocket.Conn,
+	sessionID string,
 	room *Room,
 	player *Player,
 ) *connectionManager {
@@ -44,14 +50,42 @@ func newConnectionManager(
 		config,
 		log,
 		connection,
+		sessionID,
 		room,
 		player,
+		FORMAT_HTML,
+		make(chan []byte, 16),
+		make(chan struct{}),
+		"",
+		false,
+	}
+}
+
+/**
+ * Queues a message to be sent over the connection, closing the connection if the
+ * client isn't keeping up with the messages being sent to it. Nil messages, as are made
+ * of messages that could not be built, are not sent.
+ */
+func (c *connectionManager) send(message []byte) {
+	if message == nil {
+		return
+	}
+
+	select {
+	case c.Msgs <- message:
+	default:
+		go c.Conn.Close()
 	}
 }
 
 func (c *connectionManager) readPump() {
+	defer close(c.done)
 	defer c.Conn.Close()
-	defer c.Room.removePlayer(c.Player.SessionID)
+	defer c.Room.post(
+		func() {
+			c.Room.disconnectPlayer(c)
+		},
+	)
 
 	c.Conn.SetReadLimit(MAX_MESSAGE_SIZE)
 	c.Conn.SetReadDeadline(time.Now().Add(PONG_PERIOD))
@@ -63,14 +97,17 @@ func (c *connectionManager) readPump() {
 	)
 
 	for {
-		comm := &command{}
-		err := c.Conn.ReadJSON(comm)
+		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			c.Log.Error(err.Error())
 			break
 		}
 
-		c.Room.processCommand(comm, c)
+		c.Room.post(
+			func() {
+				c.Room.processMessage(message, c)
+			},
+		)
 	}
 }
 
@@ -82,7 +119,7 @@ func (c *connectionManager) writePump() {
 
 	for {
 		select {
-		case message := <-c.Player.Msgs:
+		case message := <-c.Msgs:
 			c.Conn.SetWriteDeadline(time.Now().Add(WRITE_WAIT))
 
 			/**
@@ -119,6 +156,8 @@ func (c *connectionManager) writePump() {
 				c.Log.Error(err.Error())
 				return
 			}
+		case <-c.done:
+			return
 		}
 	}
 }
@@ -0,0 +1,81 @@
+package room
+
+// Number of events that may be queued for a room before posting to it blocks.
+const EVENT_QUEUE_SIZE = 64
+
+/**
+ * Each room owns its state in a single goroutine, which runs the events posted to the
+ * room one at a time - commands from players, players joining and leaving, timers firing
+ * and so on. All reads and writes of the room's state, including that of its players and
+ * grid, must happen within an event, so that the state need not be locked. Anything the
+ * room shares with other rooms or handlers, such as the random number generator, must
+ * still be safe for concurrent use. After each event, the room is scheduled to be saved
+ * to its store should it have one.
+ */
+func (r *Room) run() {
+	if r.writer != nil {
+		go r.writer.run()
+	}
+
+	for {
+		select {
+		case event := <-r.events:
+			event()
+			r.schedulePersist()
+		case <-r.persistDue:
+			r.persist()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+/**
+ * Queues an event to be run by the room, returning false if the room has stopped and so
+ * the event will never run.
+ */
+func (r *Room) post(event func()) bool {
+	select {
+	case r.events <- event:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+/**
+ * Runs an event on the room and waits for it to finish, returning false if the room has
+ * stopped and so the event never ran. Must not be called from within an event.
+ */
+func (r *Room) call(event func()) bool {
+	finished := make(chan struct{})
+
+	posted := r.post(
+		func() {
+			defer close(finished)
+			event()
+		},
+	)
+	if !posted {
+		return false
+	}
+
+	select {
+	case <-finished:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+/**
+ * Stops the room's event loop. Any events still queued are dropped. Expected to be called
+ * from within an event.
+ */
+func (r *Room) stop() {
+	r.stopOnce.Do(
+		func() {
+			close(r.done)
+		},
+	)
+}
@@ -0,0 +1,432 @@
+package room
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+// Number of finished games each room keeps the log of for replaying.
+const MAX_KEPT_GAMES = 10
+
+type gameEventType string
+
+const (
+	GAME_STARTED   gameEventType = "game-started"
+	CLUE_SUGGESTED gameEventType = "clue-suggested"
+	CARD_VOTED     gameEventType = "card-voted"
+	CARD_UNVOTED   gameEventType = "card-unvoted"
+	GUESSING_ENDED gameEventType = "guessing-ended"
+	CARD_REVEALED  gameEventType = "card-revealed"
+	NO_CARD_VOTED  gameEventType = "no-card-voted"
+	VOTE_TIED      gameEventType = "vote-tied"
+	PLAYER_LEFT    gameEventType = "player-left"
+	ROLE_CHANGED   gameEventType = "role-changed"
+	GAME_WON       gameEventType = "game-won"
+	MEETING_CALLED gameEventType = "meeting-called"
+	MEETING_ENDED  gameEventType = "meeting-ended"
+	PLAYER_EJECTED gameEventType = "player-ejected"
+)
+
+type gameEventPlayer struct {
+	ID   int        `json:"id"`
+	Name string     `json:"name"`
+	Role PlayerRole `json:"role"`
+}
+
+type gameEventScore struct {
+	gameEventPlayer
+	Score int `json:"score"`
+}
+
+/**
+ * Something that happened in a game. Which of the fields are set depends on the type of
+ * the event.
+ */
+type GameEvent struct {
+	Seq  int           `json:"seq"`
+	At   time.Time     `json:"at"`
+	Type gameEventType `json:"type"`
+
+	Player *gameEventPlayer `json:"player,omitempty"` // Who caused the event.
+
+	Card    int    `json:"card"`
+	Cards   []int  `json:"cards,omitempty"`
+	Clue    string `json:"clue,omitempty"`
+	Count   int    `json:"count,omitempty"`
+	Outcome string `json:"outcome,omitempty"` // How a vote was settled.
+
+	Words   []string          `json:"words,omitempty"`
+	Types   []grid.CardType   `json:"types,omitempty"`
+	Players []gameEventPlayer `json:"players,omitempty"`
+
+	Winner PlayerRole       `json:"winner,omitempty"`
+	Scores []gameEventScore `json:"scores,omitempty"`
+}
+
+/**
+ * The ordered log of every event in a game, from which the game's state at any point may
+ * be rebuilt.
+ */
+type GameLog struct {
+	ID       int         `json:"id"`
+	Finished bool        `json:"finished"`
+	Events   []GameEvent `json:"events"`
+}
+
+func eventPlayer(player *Player) *gameEventPlayer {
+	return &gameEventPlayer{player.ID, player.Name, player.Role}
+}
+
+/**
+ * Begins the log of a new game, dropping the log of any game that was abandoned for it
+ * and the logs of the oldest finished games beyond MAX_KEPT_GAMES.
+ */
+func (r *Room) beginGameLog() {
+	if len(r.Games) > 0 && !r.Games[len(r.Games)-1].Finished {
+		r.Games = r.Games[:len(r.Games)-1]
+	}
+
+	if len(r.Games) >= MAX_KEPT_GAMES {
+		r.Games = r.Games[len(r.Games)-MAX_KEPT_GAMES+1:]
+	}
+
+	r.GamesStarted += 1
+
+	r.Games = append(r.Games, &GameLog{ID: r.GamesStarted, Events: make([]GameEvent, 0)})
+}
+
+/**
+ * Appends an event to the log of the ongoing game.
+ */
+func (r *Room) record(event GameEvent) {
+	if len(r.Games) == 0 {
+		return
+	}
+
+	log := r.Games[len(r.Games)-1]
+
+	event.Seq = len(log.Events)
+	event.At = time.Now()
+
+	log.Events = append(log.Events, event)
+
+	if event.Type == GAME_WON {
+		log.Finished = true
+	}
+}
+
+func (r *Room) recordGameStarted() {
+	event := GameEvent{
+		Type:    GAME_STARTED,
+		Words:   make([]string, 0, 25),
+		Types:   make([]grid.CardType, 0, 25),
+		Players: make([]gameEventPlayer, 0, len(r.Players)),
+	}
+
+	for _, card := range r.Grid.Cards {
+		event.Words = append(event.Words, card.Word)
+		event.Types = append(event.Types, card.Type)
+	}
+
+	for _, player := range r.Players {
+		event.Players = append(event.Players, *eventPlayer(player))
+	}
+
+	r.record(event)
+}
+
+/**
+ * Gets the link to the replay of the current game, should it have finished.
+ */
+func (r *Room) replayURL() string {
+	game := r.currentGameID()
+	if game == 0 || !r.Games[len(r.Games)-1].Finished {
+		return ""
+	}
+
+	return fmt.Sprintf("/room/%s/replay/%d", r.Name, game)
+}
+
+func (r *Room) currentGameID() int {
+	if len(r.Games) == 0 {
+		return 0
+	}
+
+	return r.Games[len(r.Games)-1].ID
+}
+
+/**
+ * Gets the log of a finished game in the room.
+ */
+func (r *Room) FinishedGame(id int) (*GameLog, error) {
+	var log *GameLog
+
+	r.call(
+		func() {
+			for _, game := range r.Games {
+				if game.ID == id && game.Finished {
+					// Events are only ever appended, so the log may be shared as is.
+					log = &GameLog{game.ID, game.Finished, game.Events}
+				}
+			}
+		},
+	)
+
+	if log == nil {
+		return nil, fmt.Errorf("no finished game %d in room %s", id, r.Name)
+	}
+
+	return log, nil
+}
+
+type replayCard struct {
+	Word     string
+	Type     grid.CardType
+	Selected bool
+	Votes    map[int]struct{}
+}
+
+type replayPlayer struct {
+	Name    string
+	Role    PlayerRole
+	Ejected bool
+}
+
+/**
+ * The state of a game as rebuilt from its log.
+ */
+type ReplayState struct {
+	Cards       [25]replayCard
+	Players     map[int]*replayPlayer
+	Turn        PlayerRole
+	Clue        string
+	ClueMatches int
+	Finished    bool
+	Winner      PlayerRole
+}
+
+/**
+ * Rebuilds the state of a game by folding the given events of its log.
+ */
+func FoldGameEvents(events []GameEvent) *ReplayState {
+	state := &ReplayState{
+		Players: make(map[int]*replayPlayer),
+	}
+
+	for _, event := range events {
+		state.apply(event)
+	}
+
+	return state
+}
+
+func (s *ReplayState) apply(event GameEvent) {
+	switch event.Type {
+	case GAME_STARTED:
+		for index := range s.Cards {
+			s.Cards[index] = replayCard{event.Words[index], event.Types[index], false, make(map[int]struct{})}
+		}
+		for _, player := range event.Players {
+			s.Players[player.ID] = &replayPlayer{player.Name, player.Role, false}
+		}
+		s.Turn = SPYMASTER
+	case CLUE_SUGGESTED:
+		s.Turn = SPY
+		s.Clue = event.Clue
+		s.ClueMatches = event.Count
+		for index := range s.Cards {
+			s.Cards[index].Votes = make(map[int]struct{})
+		}
+	case CARD_VOTED:
+		s.Cards[event.Card].Votes[event.Player.ID] = struct{}{}
+	case CARD_UNVOTED:
+		delete(s.Cards[event.Card].Votes, event.Player.ID)
+	case CARD_REVEALED:
+		s.Cards[event.Card].Selected = true
+		s.Turn = SPYMASTER
+	case NO_CARD_VOTED:
+		s.Turn = SPYMASTER
+	case VOTE_TIED:
+		if event.Outcome == string(TIE_NONE) {
+			s.Turn = SPYMASTER
+		} else {
+			// Voting goes on, even should cards have been revealed this round.
+			s.Turn = SPY
+		}
+
+		if event.Outcome == string(TIE_RUNOFF) {
+			for _, index := range event.Cards {
+				s.Cards[index].Votes = make(map[int]struct{})
+			}
+		}
+	case PLAYER_LEFT:
+		for index := range s.Cards {
+			if !s.Cards[index].Selected {
+				delete(s.Cards[index].Votes, event.Player.ID)
+			}
+		}
+		delete(s.Players, event.Player.ID)
+	case ROLE_CHANGED:
+		if player, exists := s.Players[event.Player.ID]; exists {
+			player.Role = event.Player.Role
+		}
+		if event.Player.Role == SPYMASTER {
+			for index := range s.Cards {
+				if !s.Cards[index].Selected {
+					delete(s.Cards[index].Votes, event.Player.ID)
+				}
+			}
+		}
+	case PLAYER_EJECTED:
+		for index := range s.Cards {
+			if !s.Cards[index].Selected {
+				delete(s.Cards[index].Votes, event.Player.ID)
+			}
+		}
+		if player, exists := s.Players[event.Player.ID]; exists {
+			player.Ejected = true
+		}
+	case GAME_WON:
+		s.Finished = true
+		s.Winner = event.Winner
+	}
+}
+
+/**
+ * Describes an event for those watching a replay.
+ */
+func describeGameEvent(event GameEvent, state *ReplayState) string {
+	name := ""
+	if event.Player != nil {
+		name = event.Player.Name
+	}
+
+	switch event.Type {
+	case GAME_STARTED:
+		return "The game began."
+	case CLUE_SUGGESTED:
+		return fmt.Sprintf("%s gave the clue \"%s\" for %d.", name, event.Clue, event.Count)
+	case CARD_VOTED:
+		return fmt.Sprintf("%s voted for \"%s\".", name, state.Cards[event.Card].Word)
+	case CARD_UNVOTED:
+		return fmt.Sprintf("%s took back their vote for \"%s\".", name, state.Cards[event.Card].Word)
+	case GUESSING_ENDED:
+		return fmt.Sprintf("%s ended guessing.", name)
+	case CARD_REVEALED:
+		card := state.Cards[event.Card]
+		return fmt.Sprintf("%s\"%s\" was revealed as a %s card.", describeRevealReason(event.Outcome), card.Word, getCardTypeClass(card.Type))
+	case NO_CARD_VOTED:
+		return "Voting ended without any votes."
+	case VOTE_TIED:
+		words := make([]string, 0, len(event.Cards))
+		for _, index := range event.Cards {
+			words = append(words, fmt.Sprintf("\"%s\"", state.Cards[index].Word))
+		}
+		return fmt.Sprintf("The vote tied between %s, %s", strings.Join(words, ", "), describeTieBreak(tieBreakPolicy(event.Outcome)))
+	case PLAYER_LEFT:
+		return fmt.Sprintf("%s left the game.", name)
+	case ROLE_CHANGED:
+		return fmt.Sprintf("%s became the %s.", name, getPlayerRoleClass(event.Player.Role))
+	case MEETING_CALLED:
+		return fmt.Sprintf("%s called an emergency meeting.", name)
+	case MEETING_ENDED:
+		return "The meeting ended without anyone being ejected."
+	case PLAYER_EJECTED:
+		return fmt.Sprintf("%s was ejected, and was a %s.", name, getPlayerRoleClass(event.Player.Role))
+	case GAME_WON:
+		return fmt.Sprintf("The %ss won!", getPlayerRoleClass(event.Winner))
+	}
+
+	return ""
+}
+
+func describeRevealReason(reason string) string {
+	switch reason {
+	case REVEAL_TIE_RANDOM:
+		return "Chosen at random from the tied cards, "
+	case REVEAL_TIE_SPYMASTER:
+		return "Chosen by the spymaster from the tied cards, "
+	case REVEAL_NO_VOTES_PENALTY:
+		return "As a penalty for no one voting, "
+	case REVEAL_NO_VOTES_RANDOM:
+		return "Chosen at random as no one voted, "
+	}
+
+	return ""
+}
+
+func describeTieBreak(policy tieBreakPolicy) string {
+	switch policy {
+	case TIE_NONE:
+		return "so no card was revealed."
+	case TIE_SPYMASTER:
+		return "so the spymaster chose between them."
+	case TIE_RUNOFF:
+		return "so the spies voted again between them."
+	}
+
+	return "so one was chosen at random."
+}
+
+/**
+ * Builds the view of the game as it stood after the given step of its log.
+ */
+func (l *GameLog) View(roomName string, step int) components.ReplayView {
+	step = max(min(step, len(l.Events)-1), 0)
+
+	state := FoldGameEvents(l.Events[:step+1])
+
+	view := components.ReplayView{
+		Room:        roomName,
+		Game:        l.ID,
+		Step:        step,
+		Steps:       len(l.Events),
+		Description: describeGameEvent(l.Events[step], state),
+		Cards:       make([]components.CardView, 0, 25),
+		Players:     make([]components.ReplayPlayerView, 0, len(state.Players)),
+	}
+
+	if state.Turn == SPY {
+		view.Clue = state.Clue
+		view.ClueMatches = state.ClueMatches
+	}
+
+	if state.Finished {
+		view.Winner = getPlayerRoleClass(state.Winner)
+	}
+
+	for index, card := range state.Cards {
+		view.Cards = append(
+			view.Cards,
+			components.CardView{
+				Index:    index,
+				Word:     card.Word,
+				Type:     getCardTypeClass(card.Type),
+				Selected: card.Selected,
+				Votes:    len(card.Votes),
+			},
+		)
+	}
+
+	ids := make([]int, 0, len(state.Players))
+	for id := range state.Players {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		player := state.Players[id]
+
+		view.Players = append(
+			view.Players,
+			components.ReplayPlayerView{Name: player.Name, Role: getPlayerRoleClass(player.Role), Ejected: player.Ejected},
+		)
+	}
+
+	return view
+}
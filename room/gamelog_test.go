@@ -0,0 +1,209 @@
+package room
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+/**
+ * Checks that folding the log of the ongoing game rebuilds the room's live state.
+ */
+func assertFoldMatches(t *testing.T, r *Room) {
+	t.Helper()
+
+	state := FoldGameEvents(r.Games[len(r.Games)-1].Events)
+
+	ids := make(map[string]int)
+	for _, player := range r.Players {
+		ids[player.SessionID] = player.ID
+	}
+
+	for index, card := range r.Grid.Cards {
+		folded := state.Cards[index]
+
+		if folded.Word != card.Word || folded.Type != card.Type || folded.Selected != card.Selected {
+			t.Errorf(
+				"card %d folded as (%s, %d, %t), live is (%s, %d, %t)",
+				index, folded.Word, folded.Type, folded.Selected, card.Word, card.Type, card.Selected,
+			)
+		}
+
+		if len(folded.Votes) != len(card.Votes) {
+			t.Errorf("card %d folded with %d votes, live has %d", index, len(folded.Votes), len(card.Votes))
+		}
+
+		for sessionID := range card.Votes {
+			if _, voted := folded.Votes[ids[sessionID]]; !voted {
+				t.Errorf("card %d folded without the vote of %s", index, sessionID)
+			}
+		}
+	}
+
+	if len(state.Players) != len(r.Players) {
+		t.Errorf("folded %d players, live has %d", len(state.Players), len(r.Players))
+	}
+
+	for _, player := range r.Players {
+		folded, exists := state.Players[player.ID]
+		if !exists {
+			t.Errorf("player %s missing from folded state", player.Name)
+			continue
+		}
+
+		if folded.Role != player.Role || folded.Ejected != player.Ejected {
+			t.Errorf(
+				"player %s folded as (%s, %t), live is (%s, %t)",
+				player.Name,
+				getPlayerRoleClass(folded.Role),
+				folded.Ejected,
+				getPlayerRoleClass(player.Role),
+				player.Ejected,
+			)
+		}
+	}
+
+	if state.Turn != r.Turn {
+		t.Errorf("folded turn is %s, live is %s", getPlayerRoleClass(state.Turn), getPlayerRoleClass(r.Turn))
+	}
+
+	if state.Clue != r.Clue || state.ClueMatches != r.ClueMatches {
+		t.Errorf("folded clue is (%s, %d), live is (%s, %d)", state.Clue, state.ClueMatches, r.Clue, r.ClueMatches)
+	}
+
+	if state.Finished != r.Finished || (r.Finished && state.Winner != r.Winner) {
+		t.Errorf(
+			"folded finish is (%t, %s), live is (%t, %s)",
+			state.Finished,
+			getPlayerRoleClass(state.Winner),
+			r.Finished,
+			getPlayerRoleClass(r.Winner),
+		)
+	}
+}
+
+func TestFoldGameEventsMatchesLiveGame(t *testing.T) {
+	g := startTestGame(
+		t,
+		5,
+		func(settings *Settings) {
+			settings.TieBreak = TIE_RUNOFF
+			settings.Reveals = REVEALS_CLUE_COUNT
+		},
+	)
+	r := g.room
+
+	assertFoldMatches(t, r)
+
+	// Votes are cast and withdrawn, leaving two cards tied for the most votes.
+	targets := g.cards(grid.SPY_TARGET, 3)
+	civilian := g.cards(grid.CIVILIAN, 1)[0]
+
+	g.suggestClue(2)
+
+	spies := g.spies()
+	g.vote(spies[0], targets[0], targets[1])
+	g.vote(spies[1], targets[0], targets[1])
+	g.vote(spies[2], civilian)
+	g.do(spies[2], func(conn *connectionManager) { r.unvoteCard(civilian, conn) })
+	g.vote(spies[2], targets[2])
+
+	assertFoldMatches(t, r)
+
+	// The tie goes to a runoff, clearing only the votes for the tied cards.
+	g.timeOut()
+
+	if !slices.Equal(r.TiedCards, targets[:2]) {
+		t.Fatalf("runoff between cards %v, wanted %v", r.TiedCards, targets[:2])
+	}
+
+	assertFoldMatches(t, r)
+
+	// Settling the runoff reveals a second card as the clue was for two.
+	g.vote(spies[0], targets[1])
+	g.timeOut()
+
+	if selected := g.selected(); !slices.Equal(selected, targets[1:]) {
+		t.Fatalf("revealed cards %v, wanted %v", selected, targets[1:])
+	}
+
+	assertFoldMatches(t, r)
+
+	// The spymaster leaves, and a spy is promoted in their place.
+	r.dropPlayer(g.spymaster().Player)
+
+	assertFoldMatches(t, r)
+
+	// A spy leaves mid-vote, taking their votes with them.
+	g.suggestClue(1)
+
+	spies = g.spies()
+	g.vote(spies[0], civilian)
+	g.vote(spies[1], civilian)
+
+	r.dropPlayer(spies[1].Player)
+
+	assertFoldMatches(t, r)
+
+	g.timeOut()
+
+	assertFoldMatches(t, r)
+
+	// The spies play on until they have found all of their targets.
+	for !r.Finished {
+		g.suggestClue(1)
+		g.vote(g.spies()[0], g.cards(grid.SPY_TARGET, 1)[0])
+		g.timeOut()
+
+		assertFoldMatches(t, r)
+	}
+
+	if r.Winner != SPY {
+		t.Errorf("game won by %ss, wanted spies", getPlayerRoleClass(r.Winner))
+	}
+}
+
+func TestFoldGameEventsMatchesEjection(t *testing.T) {
+	g := startTestGame(
+		t,
+		7,
+		func(settings *Settings) {
+			settings.Counterspies = 2
+		},
+	)
+	r := g.room
+
+	var counterspy *Player
+	for _, player := range r.Players {
+		if player.Role == COUNTERSPY {
+			counterspy = player
+			break
+		}
+	}
+
+	g.suggestClue(1)
+
+	// Votes of the ejected counterspy are withdrawn along with them.
+	g.vote(g.conns[counterspy.SessionID], g.cards(grid.COUNTERSPY_TARGET, 1)[0])
+
+	g.do(g.spies()[0], func(conn *connectionManager) { r.callMeeting(conn) })
+
+	assertFoldMatches(t, r)
+
+	for _, conn := range g.conns {
+		if attendsMeeting(conn.Player) {
+			g.do(conn, func(conn *connectionManager) { r.voteInMeeting(counterspy.ID, false, conn) })
+		}
+	}
+
+	if !counterspy.Ejected {
+		t.Fatal("counterspy was not ejected")
+	}
+
+	assertFoldMatches(t, r)
+
+	g.timeOut()
+
+	assertFoldMatches(t, r)
+}
@@ -0,0 +1,177 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MatthewJM96/susnames/components"
+)
+
+// How long a kicked player must wait before they may rejoin the room.
+const KICK_COOLDOWN = time.Minute
+
+/**
+ * Reports whether the session has been kicked or banned from the room, and so may not
+ * join it.
+ */
+func (r *Room) isBarred(sessionID string) bool {
+	_, banned := r.Banned[sessionID]
+	if banned {
+		return true
+	}
+
+	kickedAt, kicked := r.Kicked[sessionID]
+	if !kicked {
+		return false
+	}
+
+	if time.Since(kickedAt) >= KICK_COOLDOWN {
+		delete(r.Kicked, sessionID)
+		return false
+	}
+
+	return true
+}
+
+func (r *Room) findPlayer(id int) *Player {
+	for _, player := range r.Players {
+		if player.ID == id {
+			return player
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Finds the player a host command is targeted at, rejecting the command should the
+ * sender not be the host, or the target not be another player in the room.
+ */
+func (r *Room) findHostTarget(id int, action string, conn *connectionManager) *Player {
+	if r.Host != conn.SessionID {
+		r.reject(conn, REJECT_NOT_HOST, fmt.Sprintf("tried to %s a player but is not the host", action))
+		return nil
+	}
+
+	target := r.findPlayer(id)
+	if target == nil {
+		r.reject(conn, REJECT_NO_SUCH_PLAYER, fmt.Sprintf("tried to %s player %d who is not in the room", action, id))
+		return nil
+	}
+
+	if target == conn.Player {
+		r.reject(conn, REJECT_SELF_TARGET, fmt.Sprintf("tried to %s themselves", action))
+		return nil
+	}
+
+	return target
+}
+
+/**
+ * Removes a player from the room at the host's request. A kicked player may rejoin once
+ * KICK_COOLDOWN has passed, while a banned player may not rejoin at all.
+ */
+func (r *Room) kickPlayer(id int, ban bool, conn *connectionManager) {
+	action, done := "kick", "kicked"
+	if ban {
+		action, done = "ban", "banned"
+	}
+
+	target := r.findHostTarget(id, action, conn)
+	if target == nil {
+		return
+	}
+
+	if ban {
+		r.Banned[target.SessionID] = struct{}{}
+	} else {
+		r.Kicked[target.SessionID] = time.Now()
+	}
+
+	r.Log.Info(
+		fmt.Sprintf(
+			"(%s, %s) %s player (%s, %s) from room %s",
+			conn.Player.SessionID,
+			conn.Player.Name,
+			done,
+			target.SessionID,
+			target.Name,
+			r.Name,
+		),
+	)
+
+	for targetConn := range target.Conns {
+		if targetConn.Format == FORMAT_JSON {
+			targetConn.send(r.marshalJSONMessage("removed", "", jsonRemoval{ban}))
+		} else {
+			buf := new(bytes.Buffer)
+
+			components.Removed(ban).Render(context.Background(), buf)
+
+			targetConn.send(buf.Bytes())
+		}
+
+		targetConn.send([]byte("close"))
+	}
+
+	if target.DisconnectTimer != nil {
+		target.DisconnectTimer.Stop()
+	}
+
+	r.dropPlayer(target)
+}
+
+func (r *Room) transferHost(id int, conn *connectionManager) {
+	target := r.findHostTarget(id, "transfer host to", conn)
+	if target == nil {
+		return
+	}
+
+	r.Host = target.SessionID
+
+	r.Log.Info(
+		fmt.Sprintf(
+			"(%s, %s) made (%s, %s) host of room %s",
+			conn.Player.SessionID,
+			conn.Player.Name,
+			target.SessionID,
+			target.Name,
+			r.Name,
+		),
+	)
+
+	r.broadcastPlayerList(context.Background())
+	r.broadcastSettings(context.Background())
+	r.broadcastAccess(context.Background())
+	r.broadcastDeckUploader(context.Background())
+}
+
+/**
+ * Passes host duty on from a host who has left the room to whichever player has been in
+ * the room the longest, preferring those who are connected. Should no players remain,
+ * the next player to join becomes host.
+ */
+func (r *Room) passHost() {
+	var next *Player
+	for _, player := range r.Players {
+		if next == nil ||
+			(player.Connected() && !next.Connected()) ||
+			(player.Connected() == next.Connected() && player.ID < next.ID) {
+			next = player
+		}
+	}
+
+	if next == nil {
+		r.Host = ""
+
+		r.Log.Info(fmt.Sprintf("room %s has no host, the next player to join will become host", r.Name))
+
+		return
+	}
+
+	r.Host = next.SessionID
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) is now host of room %s", next.SessionID, next.Name, r.Name))
+}
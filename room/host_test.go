@@ -0,0 +1,48 @@
+package room
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKickedPlayerQueuedCommands(t *testing.T) {
+	for _, ban := range []bool{false, true} {
+		t.Run(
+			fmt.Sprintf("ban %t", ban),
+			func(t *testing.T) {
+				g := startTestGame(t, 5, nil)
+				r := g.room
+
+				g.suggestClue(1)
+
+				var kicked *connectionManager
+				for _, spy := range g.spies() {
+					if spy.SessionID != r.Host {
+						kicked = spy
+						break
+					}
+				}
+
+				card := 0
+
+				g.do(g.conns[r.Host], func(conn *connectionManager) { r.kickPlayer(kicked.Player.ID, ban, conn) })
+
+				// Commands the kicked player sent before being removed are yet to run.
+				r.processMessage([]byte(fmt.Sprintf(`{"v": 1, "cmd": "vote-card", "data": {"card": %d}}`, card)), kicked)
+				r.processMessage([]byte(`{"v": 1, "cmd": "end-clue-guessing"}`), kicked)
+
+				if votes := len(r.Grid.Cards[card].Votes); votes != 0 {
+					t.Errorf("card has %d votes from removed player, wanted none", votes)
+				}
+
+				if r.VoteEndVotes != 0 {
+					t.Errorf("%d votes to end guessing from removed player, wanted none", r.VoteEndVotes)
+				}
+
+				if len(kicked.Msgs) != 0 {
+					t.Errorf("sent %d messages to removed player, wanted none", len(kicked.Msgs))
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,279 @@
+package room
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+type messageFormat uint
+
+const (
+	FORMAT_HTML messageFormat = iota
+	FORMAT_JSON
+)
+
+// Subprotocol a client may request to receive JSON messages rather than HTML fragments.
+const JSON_SUBPROTOCOL = "susnames.json.v1"
+
+/**
+ * Every message sent to a JSON client is wrapped in an envelope naming the type of the
+ * message, and, for acknowledgements and rejections, the ID of the request they respond
+ * to.
+ */
+type jsonMessage struct {
+	Version int    `json:"v"`
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Data    any    `json:"data,omitempty"`
+}
+
+type jsonPlayer struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Claim     string `json:"claim"`
+	Connected bool   `json:"connected"`
+	Host      bool   `json:"host"`
+	Ejected   bool   `json:"ejected"`
+	You       bool   `json:"you"`
+}
+
+type jsonCard struct {
+	Index    int      `json:"index"`
+	Word     string   `json:"word"`
+	Selected bool     `json:"selected"`
+	Type     string   `json:"type,omitempty"`
+	Votes    int      `json:"votes"`
+	Voters   []string `json:"voters,omitempty"`
+	Voted    bool     `json:"voted"`
+}
+
+type jsonResults struct {
+	Game                      int         `json:"game"` // For fetching the game's replay.
+	Winner                    string      `json:"winner"`
+	SpyTargets                int         `json:"spyTargets"`
+	SpyTargetsRevealed        int         `json:"spyTargetsRevealed"`
+	CounterspyTargets         int         `json:"counterspyTargets"`
+	CounterspyTargetsRevealed int         `json:"counterspyTargetsRevealed"`
+	Counterspies              []string    `json:"counterspies"`
+	Ejected                   []string    `json:"ejected"`
+	Scores                    []jsonScore `json:"scores"`
+}
+
+type jsonScore struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+type jsonClue struct {
+	Clue           string `json:"clue"`
+	Matches        int    `json:"matches"`
+	CanEndGuessing bool   `json:"canEndGuessing"`
+	Tied           []int  `json:"tied,omitempty"`     // Cards tied for the most votes.
+	TieBreak       string `json:"tieBreak,omitempty"` // How the tie is being broken.
+}
+
+type jsonGameState struct {
+	Started      bool         `json:"started"`
+	Finished     bool         `json:"finished"`
+	Turn         string       `json:"turn"`
+	Role         string       `json:"role"`
+	Clue         *jsonClue    `json:"clue,omitempty"`
+	Cards        []jsonCard   `json:"cards"`
+	Meeting      *jsonMeeting `json:"meeting,omitempty"`
+	MeetingsLeft int          `json:"meetingsLeft"`
+	Results      *jsonResults `json:"results,omitempty"`
+}
+
+type jsonSettings struct {
+	Counterspies         int      `json:"counterspies"`
+	EndVotingOn          int      `json:"endVotingOn"`
+	SpyCards             int      `json:"spyCards"`
+	CounterspyCards      int      `json:"counterspyCards"`
+	CounterspyWinReveals int      `json:"counterspyWinReveals"`
+	VoteTime             int      `json:"voteTime"`
+	Deck                 string   `json:"deck"`
+	Decks                []string `json:"decks"`
+	TieBreak             string   `json:"tieBreak"`
+	NoVotes              string   `json:"noVotes"`
+	Reveals              string   `json:"reveals"`
+	Meetings             int      `json:"meetings"`
+}
+
+type jsonMeeting struct {
+	CalledBy   string `json:"calledBy"`
+	Candidates []int  `json:"candidates"` // IDs of the players who may be ejected.
+	Voted      int    `json:"voted"`
+	Voters     int    `json:"voters"`
+	CanVote    bool   `json:"canVote"`
+}
+
+type jsonAccess struct {
+	Mode        string `json:"mode"`
+	Public      bool   `json:"public"`
+	HasPasscode bool   `json:"hasPasscode"`
+	Invite      string `json:"invite,omitempty"`
+}
+
+type jsonRemoval struct {
+	Banned bool `json:"banned"`
+}
+
+type jsonRejection struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+/**
+ * Marshals a message for JSON clients. Should the message not marshal, the error is
+ * logged and nil returned, which is not sent.
+ */
+func (r *Room) marshalJSONMessage(messageType string, requestID string, data any) []byte {
+	message, err := json.Marshal(
+		jsonMessage{
+			Version: PROTOCOL_VERSION,
+			Type:    messageType,
+			ID:      requestID,
+			Data:    data,
+		},
+	)
+	if err != nil {
+		r.Log.Error(fmt.Sprintf("could not marshal %s message in room %s: %s", messageType, r.Name, err.Error()))
+		return nil
+	}
+
+	return message
+}
+
+func getCardTypeClass(cardType grid.CardType) string {
+	if cardType == grid.CIVILIAN {
+		return "civilian"
+	} else if cardType == grid.SPY_TARGET {
+		return "spy-target"
+	} else if cardType == grid.COUNTERSPY_TARGET {
+		return "counterspy-target"
+	}
+	return ""
+}
+
+func (r *Room) makePlayerListJSON(player *Player) []byte {
+	players := make([]jsonPlayer, 0, len(r.Players))
+
+	players = append(
+		players,
+		jsonPlayer{
+			player.ID,
+			player.Name,
+			getPlayerRoleClass(player.Role),
+			r.visibleClaimClass(player),
+			true,
+			r.Host == player.SessionID,
+			player.Ejected,
+			true,
+		},
+	)
+
+	for _, targetPlayer := range r.Players {
+		if player == targetPlayer {
+			continue
+		}
+
+		players = append(
+			players,
+			jsonPlayer{
+				targetPlayer.ID,
+				targetPlayer.Name,
+				r.visibleRoleClass(targetPlayer),
+				r.visibleClaimClass(targetPlayer),
+				targetPlayer.Connected(),
+				r.Host == targetPlayer.SessionID,
+				targetPlayer.Ejected,
+				false,
+			},
+		)
+	}
+
+	return r.marshalJSONMessage("players", "", players)
+}
+
+func (r *Room) makeClueJSON(player *Player) *jsonClue {
+	clue := &jsonClue{
+		Clue:           r.Clue,
+		Matches:        r.ClueMatches,
+		CanEndGuessing: r.canVote(player),
+	}
+
+	if r.TiedCards != nil {
+		clue.Tied = r.TiedCards
+		clue.TieBreak = string(r.Settings.TieBreak)
+	}
+
+	return clue
+}
+
+/**
+ * Gets the cards as the player may see them, by the same rules as the rendered grid.
+ */
+func (r *Room) makeCardsJSON(player *Player) []jsonCard {
+	cards := make([]jsonCard, 0, 25)
+
+	for _, view := range r.makeCards(player) {
+		cards = append(
+			cards,
+			jsonCard{
+				Index:    view.Index,
+				Word:     view.Word,
+				Selected: view.Selected,
+				Type:     view.Type,
+				Votes:    view.Votes,
+				Voters:   view.Voters,
+				Voted:    view.Voted,
+			},
+		)
+	}
+
+	return cards
+}
+
+func (r *Room) makeGameStateJSON(player *Player) []byte {
+	state := jsonGameState{
+		Started:  r.Started,
+		Finished: r.Finished,
+		Turn:     getPlayerRoleClass(r.Turn),
+		Role:     getPlayerRoleClass(player.Role),
+		Cards:    make([]jsonCard, 0, 25),
+	}
+
+	if r.Grid == nil {
+		return r.marshalJSONMessage("game-state", "", state)
+	}
+
+	state.Cards = r.makeCardsJSON(player)
+	state.Meeting = r.makeMeetingJSON(player)
+	state.MeetingsLeft = r.meetingsLeft()
+
+	if r.Finished {
+		state.Results = &jsonResults{
+			Game:                      r.currentGameID(),
+			Winner:                    getPlayerRoleClass(r.Winner),
+			SpyTargets:                r.Grid.CountType(grid.SPY_TARGET),
+			SpyTargetsRevealed:        r.Grid.CountSelectedOfType(grid.SPY_TARGET),
+			CounterspyTargets:         r.Grid.CountType(grid.COUNTERSPY_TARGET),
+			CounterspyTargetsRevealed: r.Grid.CountSelectedOfType(grid.COUNTERSPY_TARGET),
+			Counterspies:              r.counterspyNames(),
+			Ejected:                   r.ejectedNames(),
+			Scores:                    make([]jsonScore, 0),
+		}
+
+		for _, score := range r.scores() {
+			state.Results.Scores = append(state.Results.Scores, jsonScore{score.ID, score.Name, score.Score})
+		}
+	} else if r.Turn == SPY {
+		state.Clue = r.makeClueJSON(player)
+	}
+
+	return r.marshalJSONMessage("game-state", "", state)
+}
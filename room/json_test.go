@@ -0,0 +1,35 @@
+package room
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestMarshalJSONMessage(t *testing.T) {
+	r := &Room{Log: slog.New(slog.NewTextHandler(io.Discard, nil)), Name: "test-room"}
+
+	message := r.marshalJSONMessage("ack", "request", nil)
+
+	decoded := &jsonMessage{}
+	if err := json.Unmarshal(message, decoded); err != nil {
+		t.Fatalf("could not unmarshal message: %v", err)
+	}
+
+	if decoded.Version != PROTOCOL_VERSION || decoded.Type != "ack" || decoded.ID != "request" {
+		t.Errorf("unmarshalled message as %+v", decoded)
+	}
+
+	// Messages that can't be marshalled are dropped, rather than bringing down the room.
+	if message := r.marshalJSONMessage("bad", "", make(chan int)); message != nil {
+		t.Errorf("marshalled unmarshalable message as %s", message)
+	}
+
+	conn := &connectionManager{Msgs: make(chan []byte, 1)}
+	conn.send(nil)
+
+	if len(conn.Msgs) != 0 {
+		t.Error("queued nil message to be sent")
+	}
+}
@@ -0,0 +1,76 @@
+package room
+
+import "time"
+
+type RoomStatus string
+
+const (
+	STATUS_LOBBY       RoomStatus = "lobby"
+	STATUS_IN_PROGRESS RoomStatus = "in-progress"
+	STATUS_FINISHED    RoomStatus = "finished"
+)
+
+/**
+ * Describes a public room to those browsing for a room to join.
+ */
+type RoomListing struct {
+	Name    string
+	Players int
+	Status  RoomStatus
+	Access  string
+
+	Deck            string
+	Counterspies    int // AUTO if chosen when the game starts.
+	SpyCards        int
+	CounterspyCards int
+	VoteTime        time.Duration
+}
+
+/**
+ * Gets the listing of the room, should it be public.
+ */
+func (r *Room) Listing() (RoomListing, bool) {
+	var listing RoomListing
+	public := false
+
+	r.call(
+		func() {
+			if r.Closed || !r.Public {
+				return
+			}
+
+			public = true
+
+			status := STATUS_LOBBY
+			if r.inProgress() {
+				status = STATUS_IN_PROGRESS
+			} else if r.Started {
+				status = STATUS_FINISHED
+			}
+
+			listing = RoomListing{
+				Name:            r.Name,
+				Players:         r.connectedPlayerCount(),
+				Status:          status,
+				Access:          string(r.Access),
+				Deck:            r.Settings.Deck,
+				Counterspies:    r.Settings.Counterspies,
+				SpyCards:        r.Settings.SpyCards,
+				CounterspyCards: r.Settings.CounterspyCards,
+				VoteTime:        r.Settings.VoteTime,
+			}
+		},
+	)
+
+	return listing, public
+}
+
+/**
+ * Lets those browsing for rooms know that something they may be shown about this room
+ * has changed.
+ */
+func (r *Room) changed() {
+	if r.onChange != nil {
+		r.onChange()
+	}
+}
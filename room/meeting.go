@@ -0,0 +1,410 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/MatthewJM96/susnames/components"
+)
+
+// Most emergency meetings that a room may allow to be called in each game.
+const MAX_MEETINGS = 5
+
+/**
+ * An emergency meeting called by one of the spies, in which the players vote to eject
+ * whomever they suspect to be a counterspy. Voting in the game is paused while the
+ * meeting is under way.
+ */
+type Meeting struct {
+	ID       int               `json:"id"` // Which of the game's meetings this is.
+	CalledBy string            `json:"calledBy"`
+	Votes    map[string]string `json:"votes"` // Whom each player voted to eject, empty to skip.
+
+	Timer *time.Timer `json:"-"`
+}
+
+/**
+ * Reports whether the player takes part in meetings, as anyone playing who hasn't been
+ * ejected does.
+ */
+func attendsMeeting(player *Player) bool {
+	return player.Role != SPECTATOR && !player.Ejected
+}
+
+/**
+ * Reports whether the player may be voted out in a meeting. The spymaster is known to
+ * all, and so only the spies may be suspected.
+ */
+func ejectable(player *Player) bool {
+	return (player.Role == SPY || player.Role == COUNTERSPY) && !player.Ejected
+}
+
+func (r *Room) meetingsLeft() int {
+	return max(r.Settings.Meetings-r.MeetingsCalled, 0)
+}
+
+func (r *Room) canCallMeeting(player *Player) bool {
+	return r.inProgress() && r.Meeting == nil && ejectable(player) && r.meetingsLeft() > 0 && r.Counterspies > 0
+}
+
+func (r *Room) callMeeting(conn *connectionManager) {
+	if !r.inProgress() {
+		r.reject(conn, REJECT_NOT_YOUR_TURN, "tried to call a meeting while no game is in progress")
+		return
+	}
+
+	if r.Meeting != nil {
+		r.reject(conn, REJECT_MEETING_IN_PROGRESS, "tried to call a meeting while one was under way")
+		return
+	}
+
+	if !ejectable(conn.Player) {
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to call a meeting but is not a Spy")
+		return
+	}
+
+	if r.meetingsLeft() == 0 {
+		r.reject(conn, REJECT_NO_MEETINGS_LEFT, "tried to call a meeting but none were left")
+		return
+	}
+
+	if r.Counterspies == 0 {
+		r.reject(conn, REJECT_NO_MEETINGS_LEFT, "tried to call a meeting but there are no counterspies to find")
+		return
+	}
+
+	// Any timeout of the vote already on its way is ignored, as voting resumes afresh.
+	if r.VoteTimer != nil {
+		r.VoteTimer.Stop()
+	}
+	r.VoteRound += 1
+
+	r.MeetingsCalled += 1
+	r.Meeting = &Meeting{
+		ID:       r.MeetingsCalled,
+		CalledBy: conn.SessionID,
+		Votes:    make(map[string]string),
+	}
+
+	r.record(GameEvent{Type: MEETING_CALLED, Player: eventPlayer(conn.Player)})
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) called a meeting in room %s", conn.SessionID, conn.Player.Name, r.Name))
+
+	r.startMeetingTimer()
+
+	r.broadcastGameState(context.Background())
+}
+
+/**
+ * Starts the timer for the meeting, after which it will be ended should the players not
+ * all have voted.
+ */
+func (r *Room) startMeetingTimer() {
+	id := r.Meeting.ID
+	r.Meeting.Timer = time.AfterFunc(
+		r.Settings.VoteTime,
+		func() {
+			r.post(
+				func() {
+					if r.Meeting != nil && r.Meeting.ID == id {
+						r.Log.Info("meeting closed by timeout")
+
+						r.endMeeting()
+					}
+				},
+			)
+		},
+	)
+}
+
+/**
+ * Casts the player's vote in the meeting, to eject the given player or, should no player
+ * be given, to skip. The meeting ends as soon as everyone has voted.
+ */
+func (r *Room) voteInMeeting(targetID int, skip bool, conn *connectionManager) {
+	if r.Meeting == nil {
+		r.reject(conn, REJECT_NO_MEETING, "tried to vote in a meeting while none was under way")
+		return
+	}
+
+	if !attendsMeeting(conn.Player) {
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to vote in a meeting but is not playing")
+		return
+	}
+
+	if _, voted := r.Meeting.Votes[conn.SessionID]; voted {
+		r.reject(conn, REJECT_ALREADY_VOTED, "tried to vote in a meeting but had already")
+		return
+	}
+
+	target := ""
+	if !skip {
+		player := r.findPlayer(targetID)
+		if player == nil || !ejectable(player) {
+			r.reject(conn, REJECT_NO_SUCH_PLAYER, fmt.Sprintf("tried to vote to eject player %d who may not be ejected", targetID))
+			return
+		}
+
+		target = player.SessionID
+	}
+
+	r.Meeting.Votes[conn.SessionID] = target
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) voted in meeting in room %s", conn.SessionID, conn.Player.Name, r.Name))
+
+	if r.meetingComplete() {
+		r.Meeting.Timer.Stop()
+
+		r.endMeeting()
+	} else {
+		r.broadcastMeeting(context.Background())
+	}
+}
+
+func (r *Room) meetingComplete() bool {
+	for _, player := range r.Players {
+		if _, voted := r.Meeting.Votes[player.SessionID]; attendsMeeting(player) && !voted {
+			return false
+		}
+	}
+
+	return true
+}
+
+/**
+ * Ends the meeting, ejecting the player with the most votes should they have more votes
+ * than any other player and than were cast to skip. Should the game go on, voting picks up
+ * where it left off with its full time again.
+ */
+func (r *Room) endMeeting() {
+	tally := make(map[string]int)
+	for _, target := range r.Meeting.Votes {
+		tally[target] += 1
+	}
+
+	var ejected *Player
+	most := tally[""]
+	tied := false
+	for sessionID, votes := range tally {
+		player, exists := r.Players[sessionID]
+		if !exists || !ejectable(player) {
+			continue
+		}
+
+		if votes > most {
+			ejected = player
+			most = votes
+			tied = false
+		} else if votes == most {
+			tied = true
+		}
+	}
+
+	if tied {
+		ejected = nil
+	}
+
+	votes := r.Meeting.Votes
+	r.Meeting = nil
+
+	if ejected != nil {
+		r.scoreEjection(ejected, votes)
+		r.ejectPlayer(ejected)
+	} else {
+		r.record(GameEvent{Type: MEETING_ENDED})
+
+		r.Log.Info(fmt.Sprintf("meeting in room %s ended without an ejection", r.Name))
+	}
+
+	if r.Finished {
+		r.broadcastSettings(context.Background())
+	} else if r.Turn == SPY {
+		r.resumeVoting()
+	}
+
+	r.broadcastGameState(context.Background())
+}
+
+/**
+ * Resumes voting after a meeting, ending it should enough spies have ended guessing
+ * once the ejected player's votes no longer count.
+ */
+func (r *Room) resumeVoting() {
+	if r.endVotingIfDue() {
+		return
+	}
+
+	r.VoteRound += 1
+	r.startVoteTimer()
+}
+
+/**
+ * Ejects a player from the game, revealing their true role to all. Ejecting the last
+ * counterspy wins the game for the spies, while ejecting the last loyal spy wins it for
+ * the counterspies.
+ */
+func (r *Room) ejectPlayer(player *Player) {
+	player.Ejected = true
+
+	r.withdrawVotes(player)
+
+	r.record(GameEvent{Type: PLAYER_EJECTED, Player: eventPlayer(player)})
+
+	r.Log.Info(
+		fmt.Sprintf(
+			"(%s, %s) ejected from game in room %s as a %s",
+			player.SessionID,
+			player.Name,
+			r.Name,
+			getPlayerRoleClass(player.Role),
+		),
+	)
+
+	r.countSpies()
+
+	if player.Role == COUNTERSPY && r.Counterspies == 0 {
+		r.Winner = SPY
+	} else if player.Role == SPY && r.Spies == 0 {
+		r.Winner = COUNTERSPY
+	} else {
+		r.limitEndVotingOn()
+		return
+	}
+
+	r.Finished = true
+
+	r.recordWin()
+
+	if r.VoteTimer != nil {
+		r.VoteTimer.Stop()
+	}
+
+	r.Log.Info(fmt.Sprintf("game in room %s won by %ss after ejection", r.Name, getPlayerRoleClass(r.Winner)))
+}
+
+/**
+ * Takes the player out of any ongoing meeting as they leave the room, ending the meeting
+ * should the game be over or everyone else have voted.
+ */
+func (r *Room) leaveMeeting(player *Player) {
+	if r.Meeting == nil {
+		return
+	}
+
+	delete(r.Meeting.Votes, player.SessionID)
+
+	if r.Finished {
+		r.Meeting.Timer.Stop()
+		r.Meeting = nil
+	} else if r.meetingComplete() {
+		r.Meeting.Timer.Stop()
+		r.endMeeting()
+	}
+}
+
+func (r *Room) ejectedNames() []string {
+	ejected := make([]string, 0)
+	for _, player := range r.Players {
+		if player.Ejected {
+			ejected = append(ejected, player.Name)
+		}
+	}
+
+	sort.Strings(ejected)
+
+	return ejected
+}
+
+func (r *Room) makeMeetingView(player *Player) components.MeetingView {
+	view := components.MeetingView{
+		Candidates: make([]components.MeetingCandidateView, 0),
+		CanVote:    attendsMeeting(player),
+	}
+
+	if caller, exists := r.Players[r.Meeting.CalledBy]; exists {
+		view.CalledBy = caller.Name
+	}
+
+	for _, other := range r.Players {
+		if attendsMeeting(other) {
+			view.Voters += 1
+		}
+
+		if ejectable(other) {
+			view.Candidates = append(view.Candidates, components.MeetingCandidateView{ID: strconv.Itoa(other.ID), Name: other.Name})
+		}
+	}
+
+	sort.Slice(
+		view.Candidates,
+		func(i, j int) bool {
+			return view.Candidates[i].Name < view.Candidates[j].Name
+		},
+	)
+
+	view.Voted = len(r.Meeting.Votes)
+
+	if target, voted := r.Meeting.Votes[player.SessionID]; voted {
+		view.CanVote = false
+		view.VotedFor = "skip"
+
+		if ejected, exists := r.Players[target]; exists {
+			view.VotedFor = ejected.Name
+		}
+	}
+
+	return view
+}
+
+func (r *Room) makeMeeting(ctx context.Context, player *Player) []byte {
+	buf := new(bytes.Buffer)
+
+	if r.Meeting != nil {
+		components.Meeting(r.makeMeetingView(player)).Render(ctx, buf)
+	} else if r.canCallMeeting(player) {
+		components.MeetingCaller(r.meetingsLeft()).Render(ctx, buf)
+	} else {
+		components.EmptyMeeting().Render(ctx, buf)
+	}
+
+	return buf.Bytes()
+}
+
+func (r *Room) makeMeetingJSON(player *Player) *jsonMeeting {
+	if r.Meeting == nil {
+		return nil
+	}
+
+	view := r.makeMeetingView(player)
+
+	meeting := &jsonMeeting{
+		CalledBy:   view.CalledBy,
+		Candidates: make([]int, 0, len(view.Candidates)),
+		Voted:      view.Voted,
+		Voters:     view.Voters,
+		CanVote:    view.CanVote,
+	}
+
+	for _, candidate := range view.Candidates {
+		id, _ := strconv.Atoi(candidate.ID)
+		meeting.Candidates = append(meeting.Candidates, id)
+	}
+
+	return meeting
+}
+
+func (r *Room) broadcastMeeting(ctx context.Context) {
+	r.broadcastMessage(
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			if format == FORMAT_JSON {
+				return r.marshalJSONMessage("meeting", "", r.makeMeetingJSON(player)), false
+			}
+
+			return r.makeMeeting(ctx, player), false
+		},
+	)
+}
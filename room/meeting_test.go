@@ -0,0 +1,303 @@
+package room
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+/**
+ * Starts a game of seven players with the given number of counterspies, and has the
+ * spymaster give a clue so that the spies are voting.
+ */
+func startMeetingGame(t *testing.T, counterspies int) *testGame {
+	t.Helper()
+
+	g := startTestGame(
+		t,
+		7,
+		func(settings *Settings) {
+			settings.Counterspies = counterspies
+		},
+	)
+
+	t.Cleanup(
+		func() {
+			if g.room.Meeting != nil {
+				g.room.Meeting.Timer.Stop()
+			}
+		},
+	)
+
+	g.suggestClue(1)
+
+	return g
+}
+
+/**
+ * Gets the connections of the players with the given role yet in the game, in order of
+ * player ID.
+ */
+func (g *testGame) withRole(role PlayerRole) []*connectionManager {
+	conns := make([]*connectionManager, 0)
+	for index := 0; index < len(g.conns); index++ {
+		conn := g.conns[fmt.Sprintf("session-%d", index)]
+		if conn.Player.Role == role && !conn.Player.Ejected && g.room.Players[conn.SessionID] != nil {
+			conns = append(conns, conn)
+		}
+	}
+
+	return conns
+}
+
+/**
+ * Gets the connections of everyone who takes part in meetings, in order of player ID.
+ */
+func (g *testGame) attendees() []*connectionManager {
+	attendees := make([]*connectionManager, 0)
+	for index := 0; index < len(g.conns); index++ {
+		conn := g.conns[fmt.Sprintf("session-%d", index)]
+		if attendsMeeting(conn.Player) && g.room.Players[conn.SessionID] != nil {
+			attendees = append(attendees, conn)
+		}
+	}
+
+	return attendees
+}
+
+/**
+ * Casts a player's vote in the meeting to eject the given player, or to skip should no
+ * player be given.
+ */
+func (g *testGame) voteInMeeting(conn *connectionManager, target *Player) {
+	g.t.Helper()
+
+	if target == nil {
+		g.do(conn, func(conn *connectionManager) { g.room.voteInMeeting(0, true, conn) })
+	} else {
+		g.do(conn, func(conn *connectionManager) { g.room.voteInMeeting(target.ID, false, conn) })
+	}
+}
+
+func TestMeetingEjection(t *testing.T) {
+	tests := []struct {
+		name    string
+		votes   []string // Whom each attendee votes for, a counterspy, a spy or to skip.
+		ejected string   // Who is ejected, should anyone be.
+	}{
+		{
+			name:    "most votes",
+			votes:   []string{"counterspy", "counterspy", "counterspy", "counterspy", "spy", "spy", "skip"},
+			ejected: "counterspy",
+		},
+		{
+			name:    "more votes than skips",
+			votes:   []string{"spy", "spy", "spy", "counterspy", "counterspy", "skip", "skip"},
+			ejected: "spy",
+		},
+		{
+			name:  "tie between players",
+			votes: []string{"counterspy", "counterspy", "counterspy", "spy", "spy", "spy", "skip"},
+		},
+		{
+			name:  "tie with skips",
+			votes: []string{"counterspy", "counterspy", "counterspy", "skip", "skip", "skip", "spy"},
+		},
+		{
+			name:  "majority for skip",
+			votes: []string{"skip", "skip", "skip", "skip", "counterspy", "counterspy", "counterspy"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				g := startMeetingGame(t, 2)
+				r := g.room
+
+				targets := map[string]*Player{
+					"counterspy": g.withRole(COUNTERSPY)[0].Player,
+					"spy":        g.spies()[0].Player,
+					"skip":       nil,
+				}
+
+				g.do(g.spies()[1], func(conn *connectionManager) { r.callMeeting(conn) })
+
+				attendees := g.attendees()
+				if len(attendees) != len(test.votes) {
+					t.Fatalf("meeting has %d attendees, wanted %d", len(attendees), len(test.votes))
+				}
+
+				for index, conn := range attendees {
+					g.voteInMeeting(conn, targets[test.votes[index]])
+				}
+
+				if r.Meeting != nil {
+					t.Fatal("meeting still under way once everyone voted")
+				}
+
+				ejections := g.events(PLAYER_EJECTED)
+
+				if test.ejected == "" {
+					if len(ejections) != 0 || len(g.events(MEETING_ENDED)) != 1 {
+						t.Errorf("meeting ended with %d ejections, wanted none", len(ejections))
+					}
+
+					for _, target := range targets {
+						if target != nil && target.Ejected {
+							t.Errorf("player %s ejected, wanted none", target.Name)
+						}
+					}
+
+					return
+				}
+
+				ejected := targets[test.ejected]
+				if !ejected.Ejected || len(ejections) != 1 || ejections[0].Player.ID != ejected.ID {
+					t.Errorf("meeting ended with ejections %v, wanted %s", ejections, ejected.Name)
+				}
+
+				if r.Finished {
+					t.Error("game finished with players of both sides left")
+				}
+			},
+		)
+	}
+}
+
+func TestMeetingNeedsCounterspies(t *testing.T) {
+	g := startMeetingGame(t, 0)
+
+	g.reject(g.spies()[0], REJECT_NO_MEETINGS_LEFT, func(conn *connectionManager) { g.room.callMeeting(conn) })
+}
+
+func TestMeetingPausesVoting(t *testing.T) {
+	g := startMeetingGame(t, 2)
+	r := g.room
+
+	card := g.cards(grid.CIVILIAN, 1)[0]
+	g.vote(g.spies()[0], card)
+
+	round := r.VoteRound
+
+	g.do(g.spies()[0], func(conn *connectionManager) { r.callMeeting(conn) })
+
+	// The vote timing out during the meeting ends neither the vote nor the meeting.
+	r.endVotingOnTimeout(round)
+	r.endVotingOnTimeout(r.VoteRound)
+
+	if r.Meeting == nil || r.Turn != SPY || len(g.selected()) != 0 {
+		t.Fatal("vote timed out during meeting")
+	}
+
+	// The meeting times out, ejecting no one as no one has voted, and voting resumes.
+	r.Meeting.Timer.Stop()
+	r.endMeeting()
+
+	if r.Meeting != nil || r.Turn != SPY {
+		t.Fatal("voting did not resume once the meeting ended")
+	}
+
+	if r.VoteRound == round {
+		t.Error("voting resumed in the round it was paused in")
+	}
+
+	if votes := len(r.Grid.Cards[card].Votes); votes != 1 {
+		t.Errorf("card has %d votes once voting resumed, wanted 1", votes)
+	}
+
+	// Only the timeout of the resumed vote ends it.
+	r.endVotingOnTimeout(round)
+
+	if len(g.selected()) != 0 {
+		t.Fatal("vote paused by the meeting timed out once voting resumed")
+	}
+
+	g.timeOut()
+
+	if selected := g.selected(); len(selected) != 1 || selected[0] != card {
+		t.Errorf("revealed cards %v, wanted %d", selected, card)
+	}
+}
+
+func TestMeetingScoresEjection(t *testing.T) {
+	g := startMeetingGame(t, 2)
+	r := g.room
+
+	counterspies := g.withRole(COUNTERSPY)
+	ejected := counterspies[0].Player
+
+	g.do(g.spies()[0], func(conn *connectionManager) { r.callMeeting(conn) })
+
+	// The spies and spymaster vote out the counterspy, who votes against themselves,
+	// while the other counterspy skips.
+	for _, conn := range g.attendees() {
+		if conn == counterspies[1] {
+			g.voteInMeeting(conn, nil)
+		} else {
+			g.voteInMeeting(conn, ejected)
+		}
+	}
+
+	if !ejected.Ejected || r.Finished {
+		t.Fatal("counterspy not ejected, or the game finished with one left")
+	}
+
+	for _, player := range r.Players {
+		want := SCORE_EJECTION
+		if player == ejected {
+			want = -SCORE_EJECTION
+		} else if player == counterspies[1].Player {
+			want = 0
+		}
+
+		if player.Score != want {
+			t.Errorf("%s scored %d, wanted %d", getPlayerRoleClass(player.Role), player.Score, want)
+		}
+	}
+}
+
+func TestMeetingScoresWin(t *testing.T) {
+	g := startMeetingGame(t, 1)
+	r := g.room
+
+	counterspy := g.withRole(COUNTERSPY)[0]
+
+	g.do(g.spies()[0], func(conn *connectionManager) { r.callMeeting(conn) })
+
+	for _, conn := range g.attendees() {
+		if conn == counterspy {
+			g.voteInMeeting(conn, nil)
+		} else {
+			g.voteInMeeting(conn, counterspy.Player)
+		}
+	}
+
+	// Ejecting the last counterspy wins the game for the spies.
+	if !r.Finished || r.Winner != SPY {
+		t.Fatalf("game finished %t won by %ss, wanted won by spies", r.Finished, getPlayerRoleClass(r.Winner))
+	}
+
+	for _, player := range r.Players {
+		want := SCORE_WIN + SCORE_EJECTION
+		if player == counterspy.Player {
+			want = 0
+		}
+
+		if player.Score != want {
+			t.Errorf("%s scored %d, wanted %d", getPlayerRoleClass(player.Role), player.Score, want)
+		}
+	}
+
+	wins := g.events(GAME_WON)
+	if len(wins) != 1 {
+		t.Fatalf("logged %d wins, wanted 1", len(wins))
+	}
+
+	scores := wins[0].Scores
+	if len(scores) != len(r.Players) || scores[len(scores)-1].ID != counterspy.Player.ID {
+		t.Errorf("logged scores %v, wanted every player with the counterspy last", scores)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/MatthewJM96/susnames/session"
 	"github.com/MatthewJM96/susnames/util"
@@ -45,128 +46,341 @@ func getPublicPlayerRoleClass(role PlayerRole) string {
 }
 
 type Player struct {
+	ID        int // Identifies the player to others in the room, in order of joining.
 	SessionID string
 	Name      string
 
-	Role PlayerRole
+	Role        PlayerRole
+	ClaimedRole PlayerRole // Role chosen by the player in the lobby for the next game.
 
-	Votes int
+	Votes         int
+	EndedGuessing bool
+	Ejected       bool // Whether the player was voted out of the ongoing game in a meeting.
+	Score         int  // Points scored in the ongoing game.
 
-	Msgs      chan []byte
-	CloseConn func()
+	Conns           map[*connectionManager]struct{}
+	DisconnectedAt  time.Time
+	DisconnectTimer *time.Timer
 }
 
 func generatePlayerName() string {
 	return util.GenerateRandomTwoPartName()
 }
 
-func newPlayer(sessionID string, name string) *Player {
+func newPlayer(id int, sessionID string, name string) *Player {
 	return &Player{
-		SessionID: sessionID,
-		Name:      name,
-		Role:      SPY,
-		Votes:     0,
-		Msgs:      make(chan []byte, 16),
+		ID:          id,
+		SessionID:   sessionID,
+		Name:        name,
+		Role:        SPY,
+		ClaimedRole: SPY,
+		Votes:       0,
+		Conns:       make(map[*connectionManager]struct{}),
 	}
 }
 
+/**
+ * Reports whether the player has any live connection to the room, from any tab.
+ */
+func (p *Player) Connected() bool {
+	return len(p.Conns) > 0
+}
+
 /**
  * Creates a WebSocket connection to a player and associates them to this room. This
  * function then manages publishing messages to the player via the WebSocket connection.
- * Such messages can be queued via a channel stored with the player record.
+ * Such messages can be queued via a channel stored with each of the player's connections.
  */
 func (r *Room) ConnectPlayerToRoom(writer http.ResponseWriter, request *http.Request) {
-	sessionID := session.SessionID()
+	sessionID := session.SessionID(request.Context())
+	if sessionID == "" {
+		http.Error(writer, "no session to connect to room with", http.StatusUnauthorized)
+		return
+	}
+
+	closed := true
+	barred := false
+	r.call(
+		func() {
+			closed = r.Closed
+			barred = r.isBarred(sessionID) || !r.isAdmitted(sessionID)
+		},
+	)
+	if closed {
+		http.Error(writer, fmt.Sprintf("room has been closed: %s", r.Name), http.StatusGone)
+		return
+	}
+	if barred {
+		http.Error(writer, fmt.Sprintf("not allowed into room: %s", r.Name), http.StatusForbidden)
+		return
+	}
 
 	/**
 	 * Obtain any existing name for player - maybe they've connected to the room before.
 	 */
 
-	name := util.GenerateRandomTwoPartName()
+	// Headers must be given to the upgrader, which writes only those it is given.
+	header := http.Header{}
+
+	name := ""
 	cookie, err := request.Cookie("SN-Player-Name")
 	if err == nil {
-		name = cookie.Value
-	} else {
-		http.SetCookie(writer, r.cookie("SN-Player-Name", name))
+		name, _, err = r.Cookies.Decode("SN-Player-Name", cookie.Value)
+		if err != nil {
+			r.Log.Warn(fmt.Sprintf("rejected player name cookie for %s: %s", sessionID, err.Error()))
+		}
+	}
+
+	if err != nil {
+		name = util.GenerateRandomTwoPartName()
+
+		cookie, err = r.cookie("SN-Player-Name", name)
+		if err != nil {
+			r.Log.Error(err.Error())
+		} else {
+			header.Add("Set-Cookie", cookie.String())
+		}
 	}
 
 	/**
-	 * Add player to room.
+	 * Obtain connection to websocket.
 	 */
 
-	player, err := r.addPlayer(sessionID, name)
+	connection, err := upgrader.Upgrade(writer, request, header)
 	if err != nil {
 		r.Log.Error(err.Error())
 		return
 	}
 
+	connManager := newConnectionManager(r.Config, r.Log, connection, sessionID, r, nil)
+
 	/**
-	 * Obtain connection to websocket.
+	 * Clients that aren't htmx, such as bots, may ask for JSON messages instead of HTML
+	 * fragments, either by query parameter or by subprotocol.
 	 */
 
-	connection, err := upgrader.Upgrade(writer, request, nil)
-	if err != nil {
-		r.Log.Error(err.Error())
-		return
+	if request.URL.Query().Get("format") == "json" || connection.Subprotocol() == JSON_SUBPROTOCOL {
+		connManager.Format = FORMAT_JSON
 	}
 
-	r.Log.Info(fmt.Sprintf("websocket connection established with player (%s, %s)", sessionID, name))
+	/**
+	 * Add player to room, or reattach them if they are returning within the grace period
+	 * of having disconnected. Then broadcast the existence of the player in the room,
+	 * and if a game is ongoing, the state of that game.
+	 */
+
+	joined := r.call(
+		func() {
+			if r.Closed || r.isBarred(sessionID) || !r.isAdmitted(sessionID) {
+				return
+			}
+
+			player := r.addPlayer(sessionID, name, connManager)
+
+			r.Log.Info(fmt.Sprintf("websocket connection established with player (%s, %s)", sessionID, player.Name))
+
+			r.broadcastPlayerList(context.Background())
+			r.sendSettingsToConnection(context.Background(), connManager)
+
+			if access, skip := r.makeAccess(context.Background(), player, connManager.Format); !skip {
+				connManager.send(access)
+			}
+
+			if uploader, skip := r.makeDeckUploader(context.Background(), player, connManager.Format); !skip {
+				connManager.send(uploader)
+			}
+
+			if r.Started {
+				r.broadcastGameStateToConnection(context.Background(), connManager)
+			}
+		},
+	)
+	if !joined || connManager.Player == nil {
+		connection.Close()
+		return
+	}
 
 	/**
 	 * Set up read/write pumps to run until connection is closed.
 	 */
 
-	connManager := newConnectionManager(r.Config, r.Log, connection, r, player)
 	go connManager.readPump()
 	go connManager.writePump()
+}
 
-	/**
-	 * broadcast the existence of a new player in the room, and if a game is ongoing,
-	 * the state of that game.
-	 */
+func (r *Room) addPlayer(sessionID string, name string, conn *connectionManager) *Player {
+	player, exists := r.Players[sessionID]
+	if exists {
+		if player.DisconnectTimer != nil {
+			player.DisconnectTimer.Stop()
+			player.DisconnectTimer = nil
+		}
+
+		if player.Connected() {
+			r.Log.Info(fmt.Sprintf("added connection to player: (%s, %s) in room %s", sessionID, player.Name, r.Name))
+		} else {
+			r.Log.Info(fmt.Sprintf("reconnected player: (%s, %s) to room %s", sessionID, player.Name, r.Name))
+		}
+	} else {
+		r.NextPlayerID += 1
 
-	r.broadcastPlayerList(request.Context())
+		player = newPlayer(r.NextPlayerID, sessionID, name)
+		r.Players[sessionID] = player
 
-	if r.Started {
-		r.broadcastGameStateToPlayer(request.Context(), player)
+		// Players joining a game under way watch it, keeping their claim for the next game.
+		if r.inProgress() {
+			player.Role = SPECTATOR
+		}
+
+		r.Log.Info(fmt.Sprintf("added player: (%s, %s) to room %s", sessionID, player.Name, r.Name))
+
+		if r.Host == "" {
+			r.Host = sessionID
+
+			r.Log.Info(fmt.Sprintf("(%s, %s) is now host of room %s", sessionID, player.Name, r.Name))
+		}
 	}
-}
 
-func (r *Room) addPlayer(sessionID string, name string) (*Player, error) {
-	r.PlayersMutex.Lock()
+	player.Conns[conn] = struct{}{}
+	conn.Player = player
 
-	_, exists := r.Players[sessionID]
-	if exists {
-		return nil, fmt.Errorf("player already exists with session ID: %s", sessionID)
+	return player
+}
+
+/**
+ * Detaches the connection from its player. Once the player's last connection is gone
+ * they are marked as disconnected, but keep their seat, role and votes for the configured
+ * "reconnect_grace_period", after which they are removed from the room.
+ */
+func (r *Room) disconnectPlayer(conn *connectionManager) {
+	if !r.isSeated(conn) {
+		return
 	}
 
-	player := newPlayer(sessionID, name)
-	r.Players[sessionID] = player
+	player := conn.Player
+
+	delete(player.Conns, conn)
+
+	if player.Connected() {
+		r.Log.Info(fmt.Sprintf("closed connection of player: (%s, %s) in room %s", player.SessionID, player.Name, r.Name))
+		return
+	}
 
-	r.Log.Info(fmt.Sprintf("added player: (%s, %s) to room %s", sessionID, player.Name, r.Name))
+	gracePeriod := r.Config.GetDuration("reconnect_grace_period")
+
+	player.DisconnectedAt = time.Now()
+	player.DisconnectTimer = time.AfterFunc(
+		gracePeriod,
+		func() {
+			r.post(
+				func() {
+					r.removePlayer(conn.SessionID)
+				},
+			)
+		},
+	)
+
+	if r.connectedPlayerCount() == 0 {
+		r.EmptySince = time.Now()
+	}
 
-	r.PlayersMutex.Unlock()
+	r.Log.Info(
+		fmt.Sprintf(
+			"disconnected player: (%s, %s) from room %s, removing in %s",
+			player.SessionID,
+			player.Name,
+			r.Name,
+			gracePeriod.String(),
+		),
+	)
 
-	return player, nil
+	r.broadcastPlayerList(context.Background())
 }
 
-func (r *Room) removePlayer(sessionID string) error {
-	r.PlayersMutex.Lock()
-
+/**
+ * Removes a player who has not reconnected within the grace period, reassigning roles
+ * should their leaving affect an ongoing game. Players who reconnected, or disconnected
+ * again since the grace period began, are left be.
+ */
+func (r *Room) removePlayer(sessionID string) {
 	player, exists := r.Players[sessionID]
-	if !exists {
-		return fmt.Errorf("player with session ID does not exist to remove from room: %s", sessionID)
+	if !exists || player.Connected() {
+		return
+	}
+
+	if time.Since(player.DisconnectedAt) < r.Config.GetDuration("reconnect_grace_period") {
+		return
 	}
 
 	r.Log.Info(fmt.Sprintf("removed player: (%s, %s) from room %s", sessionID, player.Name, r.Name))
 
+	r.dropPlayer(player)
+}
+
+/**
+ * Drops a player from the room, reassigning roles should their leaving affect an ongoing
+ * game, and passing on host duty should they have been the host.
+ */
+func (r *Room) dropPlayer(player *Player) {
+	sessionID := player.SessionID
+
 	delete(r.Players, sessionID)
 
-	r.PlayersMutex.Unlock()
+	// Who may change the settings changes with the host, or with the game ending.
+	settingsLocksChanged := false
 
-	r.broadcastPlayerList(context.Background())
+	if r.Host == sessionID {
+		r.passHost()
+		r.broadcastAccess(context.Background())
+		r.broadcastDeckUploader(context.Background())
+		settingsLocksChanged = true
+	}
+
+	if r.inProgress() {
+		r.record(GameEvent{Type: PLAYER_LEFT, Player: eventPlayer(player)})
+
+		r.withdrawVotes(player)
+		r.reassignRoles(player)
+		r.leaveMeeting(player)
+		r.endVotingIfDue()
+
+		settingsLocksChanged = settingsLocksChanged || r.Finished
+	}
+
+	if r.Started {
+		r.broadcastGameState(context.Background())
+	} else {
+		r.broadcastPlayerList(context.Background())
+	}
+
+	if settingsLocksChanged {
+		r.broadcastSettings(context.Background())
+	}
+}
+
+/**
+ * Reports whether the connection belongs to a player yet in the room. Connections of
+ * players who have been removed, as when kicked, may still have events queued on the
+ * room, which must not act on their behalf, even should they since have rejoined.
+ */
+func (r *Room) isSeated(conn *connectionManager) bool {
+	player, exists := r.Players[conn.SessionID]
+
+	return exists && player == conn.Player
+}
+
+/**
+ * Counts the players with a live connection to the room.
+ */
+func (r *Room) connectedPlayerCount() int {
+	count := 0
+	for _, player := range r.Players {
+		if player.Connected() {
+			count += 1
+		}
+	}
 
-	return nil
+	return count
 }
 
 func (r *Room) getPlayer(sessionID string) (*Player, error) {
@@ -178,18 +392,8 @@ func (r *Room) getPlayer(sessionID string) (*Player, error) {
 	return player, nil
 }
 
-func (r *Room) setPlayerName(name string) {
-	sessionID := session.SessionID()
-
-	/**
-	 * Get player to set name of.
-	 */
-
-	player, err := r.getPlayer(sessionID)
-	if err != nil {
-		r.Log.Error(err.Error())
-		return
-	}
+func (r *Room) setPlayerName(name string, conn *connectionManager) {
+	player := conn.Player
 
 	/**
 	 * Generare a player name if we weren't given one. If in any case the name is not
@@ -203,7 +407,7 @@ func (r *Room) setPlayerName(name string) {
 		return
 	}
 
-	r.Log.Info(fmt.Sprintf("set player name: (%s, %s) to %s", sessionID, player.Name, name))
+	r.Log.Info(fmt.Sprintf("set player name: (%s, %s) to %s", player.SessionID, player.Name, name))
 
 	/**
 	 * Set player name and broadcast the change.
@@ -213,3 +417,41 @@ func (r *Room) setPlayerName(name string) {
 
 	r.broadcastPlayerList(context.Background())
 }
+
+/**
+ * Claims a role for the player in the next game. Players may claim to be the spymaster,
+ * so long as no one else has, to spectate, or to be one of the spies, leaving who among
+ * them are counterspies to chance. Claims are locked while a game is in progress.
+ */
+func (r *Room) claimRole(role PlayerRole, conn *connectionManager) {
+	player := conn.Player
+
+	if r.inProgress() {
+		r.reject(conn, REJECT_GAME_IN_PROGRESS, fmt.Sprintf("tried to claim %s role while a game is in progress", getPlayerRoleClass(role)))
+		return
+	}
+
+	if role == SPYMASTER {
+		for _, otherPlayer := range r.Players {
+			if otherPlayer != player && otherPlayer.ClaimedRole == SPYMASTER {
+				r.reject(conn, REJECT_ROLE_TAKEN, fmt.Sprintf("tried to claim spymaster role already claimed by (%s, %s)", otherPlayer.SessionID, otherPlayer.Name))
+				return
+			}
+		}
+	}
+
+	if player.ClaimedRole == role {
+		return
+	}
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) claimed %s role in room %s", player.SessionID, player.Name, getPlayerRoleClass(role), r.Name))
+
+	player.ClaimedRole = role
+
+	// Until the first game starts, the claimed role is the player's role.
+	if !r.Started {
+		player.Role = role
+	}
+
+	r.broadcastPlayerList(context.Background())
+}
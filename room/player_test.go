@@ -0,0 +1,60 @@
+package room
+
+import (
+	"testing"
+)
+
+func TestJoinDuringGame(t *testing.T) {
+	g := startTestGame(
+		t,
+		5,
+		func(settings *Settings) {
+			settings.Counterspies = 1
+		},
+	)
+	r := g.room
+
+	g.suggestClue(1)
+
+	conn := g.join()
+	player := conn.Player
+
+	if player.Role != SPECTATOR || player.ClaimedRole != SPY {
+		t.Fatalf(
+			"player joined as %s claiming %s, wanted spectator claiming spy",
+			getPlayerRoleClass(player.Role),
+			getPlayerRoleClass(player.ClaimedRole),
+		)
+	}
+
+	// Having not been dealt into the game, the player takes no part in it.
+	g.reject(conn, REJECT_WRONG_ROLE, func(conn *connectionManager) { r.voteCard(0, conn) })
+	g.reject(conn, REJECT_WRONG_ROLE, func(conn *connectionManager) { r.endClueGuessing(conn) })
+	g.reject(conn, REJECT_WRONG_ROLE, func(conn *connectionManager) { r.callMeeting(conn) })
+
+	// Nor do they count towards either side, as spies are recounted when players leave.
+	r.countSpies()
+
+	if r.Spies != 3 || r.Counterspies != 1 {
+		t.Errorf("counted %d spies and %d counterspies, wanted 3 and 1", r.Spies, r.Counterspies)
+	}
+
+	// Once the game is over, the player is dealt into the next as they claimed.
+	r.Finished = true
+
+	g.do(g.conns[r.Host], func(conn *connectionManager) { r.startGame(conn) })
+
+	if player.Role == SPECTATOR {
+		t.Error("player left as a spectator in the next game")
+	}
+}
+
+func TestJoinInLobby(t *testing.T) {
+	r := newTestRoom(t)
+
+	conn := &connectionManager{SessionID: "session", Room: r, Format: FORMAT_JSON, Msgs: make(chan []byte, 256)}
+
+	if player := r.addPlayer("session", "player", conn); player.Role != SPY {
+		t.Errorf("player joined the lobby as %s, wanted spy", getPlayerRoleClass(player.Role))
+	}
+}
@@ -0,0 +1,456 @@
+package room
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Version of the command protocol spoken over room WebSocket connections.
+const PROTOCOL_VERSION = 1
+
+// Longest name, in characters, that a player may give themselves.
+const MAX_PLAYER_NAME_LENGTH = 32
+
+/**
+ * Every command sent by a client is wrapped in an envelope naming the command, along
+ * with the protocol version the client speaks and, optionally, a request ID which will
+ * be echoed back in the acknowledgement or rejection of the command. The command's
+ * payload may either be nested under "data" or, as htmx sends form values, placed
+ * alongside the envelope's own fields.
+ */
+type envelope struct {
+	Version flexInt         `json:"v"`
+	ID      string          `json:"id"`
+	Cmd     string          `json:"cmd"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type rejection struct {
+	code   rejectionCode
+	reason string
+}
+
+/**
+ * Payloads may validate themselves once decoded, returning a rejection if invalid.
+ */
+type validator interface {
+	validate() *rejection
+}
+
+/**
+ * An integer that may be given either as a JSON number or as a string holding a number,
+ * as form inputs are always sent as strings.
+ */
+type flexInt int
+
+func (i *flexInt) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(bytes.Trim(data, `"`)))
+
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("could not parse as integer: %s", string(data))
+	}
+
+	*i = flexInt(value)
+
+	return nil
+}
+
+type commandSpec struct {
+	malformed rejectionCode
+	dispatch  func(r *Room, conn *connectionManager, data []byte) *rejection
+}
+
+/**
+ * Defines a command whose payload decodes into T. Should the payload fail to decode, the
+ * command is rejected with the malformed code given.
+ */
+func defineCommand[T any](malformed rejectionCode, handle func(*Room, *connectionManager, *T)) commandSpec {
+	return commandSpec{
+		malformed: malformed,
+		dispatch: func(r *Room, conn *connectionManager, data []byte) *rejection {
+			payload := new(T)
+
+			err := json.Unmarshal(data, payload)
+			if err != nil {
+				return &rejection{malformed, fmt.Sprintf("sent malformed payload: %s", err.Error())}
+			}
+
+			if v, ok := any(payload).(validator); ok {
+				if rej := v.validate(); rej != nil {
+					return rej
+				}
+			}
+
+			handle(r, conn, payload)
+
+			return nil
+		},
+	}
+}
+
+type emptyPayload struct{}
+
+type suggestCluePayload struct {
+	Clue  string   `json:"clue"`
+	Count *flexInt `json:"count"`
+}
+
+func (p *suggestCluePayload) validate() *rejection {
+	p.Clue = strings.TrimSpace(p.Clue)
+
+	if p.Count == nil {
+		return &rejection{REJECT_INVALID_COUNT, "suggested clue without a count"}
+	}
+
+	if p.Clue == "" || strings.ContainsAny(p.Clue, " \t\r\n") || *p.Count < 0 {
+		return &rejection{REJECT_INVALID_CLUE, fmt.Sprintf("suggested invalid clue (%s, %d)", p.Clue, *p.Count)}
+	}
+
+	return nil
+}
+
+type cardPayload struct {
+	Card *flexInt `json:"card"`
+}
+
+func (p *cardPayload) validate() *rejection {
+	if p.Card == nil {
+		return &rejection{REJECT_INVALID_CARD, "sent card command without a card"}
+	}
+
+	if *p.Card < 0 || *p.Card >= 25 {
+		return &rejection{REJECT_INVALID_CARD, fmt.Sprintf("sent card index %d out-of-range", *p.Card)}
+	}
+
+	return nil
+}
+
+type changeNamePayload struct {
+	Name string `json:"name"`
+}
+
+func (p *changeNamePayload) validate() *rejection {
+	p.Name = strings.TrimSpace(p.Name)
+
+	if utf8.RuneCountInString(p.Name) > MAX_PLAYER_NAME_LENGTH {
+		return &rejection{REJECT_INVALID_NAME, fmt.Sprintf("tried to take a name over %d characters", MAX_PLAYER_NAME_LENGTH)}
+	}
+
+	return nil
+}
+
+/**
+ * An integer setting which may be left empty, or given as "auto" or -1, to have it chosen
+ * automatically when the game starts.
+ */
+type autoInt int
+
+func (i *autoInt) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(bytes.Trim(data, `"`)))
+	if text == "" || text == "auto" {
+		*i = AUTO
+		return nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("could not parse as integer: %s", string(data))
+	}
+
+	*i = autoInt(value)
+
+	return nil
+}
+
+type updateSettingsPayload struct {
+	Counterspies         *autoInt `json:"counterspies"`
+	EndVotingOn          *autoInt `json:"endVotingOn"`
+	SpyCards             *flexInt `json:"spyCards"`
+	CounterspyCards      *flexInt `json:"counterspyCards"`
+	CounterspyWinReveals *autoInt `json:"counterspyWinReveals"`
+	VoteTime             *flexInt `json:"voteTime"` // In seconds.
+	Deck                 string   `json:"deck"`
+	TieBreak             string   `json:"tieBreak"`
+	NoVotes              string   `json:"noVotes"`
+	Reveals              string   `json:"reveals"`
+	Meetings             *flexInt `json:"meetings"`
+}
+
+/**
+ * Builds the settings the payload describes, leaving any setting not given as it was.
+ */
+func (p *updateSettingsPayload) settings(current Settings) Settings {
+	settings := current
+
+	if p.Counterspies != nil {
+		settings.Counterspies = int(*p.Counterspies)
+	}
+	if p.EndVotingOn != nil {
+		settings.EndVotingOn = int(*p.EndVotingOn)
+	}
+	if p.SpyCards != nil {
+		settings.SpyCards = int(*p.SpyCards)
+	}
+	if p.CounterspyCards != nil {
+		settings.CounterspyCards = int(*p.CounterspyCards)
+	}
+	if p.CounterspyWinReveals != nil {
+		settings.CounterspyWinReveals = int(*p.CounterspyWinReveals)
+	}
+	if p.VoteTime != nil {
+		settings.VoteTime = time.Duration(*p.VoteTime) * time.Second
+	}
+	if p.Deck != "" {
+		settings.Deck = p.Deck
+	}
+	if p.TieBreak != "" {
+		settings.TieBreak = tieBreakPolicy(p.TieBreak)
+	}
+	if p.NoVotes != "" {
+		settings.NoVotes = noVotesPolicy(p.NoVotes)
+	}
+	if p.Reveals != "" {
+		settings.Reveals = revealsPolicy(p.Reveals)
+	}
+	if p.Meetings != nil {
+		settings.Meetings = int(*p.Meetings)
+	}
+
+	return settings
+}
+
+/**
+ * A vote in a meeting, to eject the given player or, should no player be given, to skip.
+ */
+type meetingVotePayload struct {
+	Player *flexInt `json:"player"`
+	Skip   bool     `json:"skip"`
+}
+
+func (p *meetingVotePayload) validate() *rejection {
+	if p.Player == nil && !p.Skip {
+		return &rejection{REJECT_NO_SUCH_PLAYER, "voted in meeting without a player or skip"}
+	}
+
+	return nil
+}
+
+type claimRolePayload struct {
+	Role string `json:"role"`
+
+	role PlayerRole
+}
+
+func (p *claimRolePayload) validate() *rejection {
+	switch p.Role {
+	case "spymaster":
+		p.role = SPYMASTER
+	case "spy":
+		p.role = SPY
+	case "spectator":
+		p.role = SPECTATOR
+	default:
+		return &rejection{REJECT_INVALID_ROLE, fmt.Sprintf("tried to claim unclaimable role: %s", p.Role)}
+	}
+
+	return nil
+}
+
+type playerPayload struct {
+	Player *flexInt `json:"player"`
+}
+
+func (p *playerPayload) validate() *rejection {
+	if p.Player == nil {
+		return &rejection{REJECT_NO_SUCH_PLAYER, "sent player command without a player"}
+	}
+
+	return nil
+}
+
+type updateAccessPayload struct {
+	Access   string `json:"access"`
+	Passcode string `json:"passcode"`
+	Listing  string `json:"listing"` // Either "public" or "unlisted".
+}
+
+func (p *updateAccessPayload) validate() *rejection {
+	mode := accessMode(p.Access)
+	if mode != ACCESS_OPEN && mode != ACCESS_PASSCODE && mode != ACCESS_INVITE {
+		return &rejection{REJECT_INVALID_ACCESS, fmt.Sprintf("tried to set unknown room access: %s", p.Access)}
+	}
+
+	if p.Listing != "" && p.Listing != "public" && p.Listing != "unlisted" {
+		return &rejection{REJECT_INVALID_ACCESS, fmt.Sprintf("tried to set unknown room listing: %s", p.Listing)}
+	}
+
+	if utf8.RuneCountInString(p.Passcode) > MAX_PASSCODE_LENGTH || len(p.Passcode) > MAX_PASSCODE_BYTES {
+		return &rejection{REJECT_INVALID_ACCESS, fmt.Sprintf("tried to set a passcode over %d characters", MAX_PASSCODE_LENGTH)}
+	}
+
+	return nil
+}
+
+var commands = map[string]commandSpec{
+	"start-game": defineCommand(
+		REJECT_MALFORMED,
+		func(r *Room, conn *connectionManager, _ *emptyPayload) {
+			r.startGame(conn)
+		},
+	),
+	"suggest-clue": defineCommand(
+		REJECT_INVALID_COUNT,
+		func(r *Room, conn *connectionManager, p *suggestCluePayload) {
+			r.suggestClue(p.Clue, int(*p.Count), conn)
+		},
+	),
+	"vote-card": defineCommand(
+		REJECT_INVALID_CARD,
+		func(r *Room, conn *connectionManager, p *cardPayload) {
+			r.voteCard(int(*p.Card), conn)
+		},
+	),
+	"unvote-card": defineCommand(
+		REJECT_INVALID_CARD,
+		func(r *Room, conn *connectionManager, p *cardPayload) {
+			r.unvoteCard(int(*p.Card), conn)
+		},
+	),
+	"break-tie": defineCommand(
+		REJECT_INVALID_CARD,
+		func(r *Room, conn *connectionManager, p *cardPayload) {
+			r.breakTie(int(*p.Card), conn)
+		},
+	),
+	"call-meeting": defineCommand(
+		REJECT_MALFORMED,
+		func(r *Room, conn *connectionManager, _ *emptyPayload) {
+			r.callMeeting(conn)
+		},
+	),
+	"meeting-vote": defineCommand(
+		REJECT_NO_SUCH_PLAYER,
+		func(r *Room, conn *connectionManager, p *meetingVotePayload) {
+			target := 0
+			if p.Player != nil {
+				target = int(*p.Player)
+			}
+
+			r.voteInMeeting(target, p.Player == nil, conn)
+		},
+	),
+	"end-clue-guessing": defineCommand(
+		REJECT_MALFORMED,
+		func(r *Room, conn *connectionManager, _ *emptyPayload) {
+			r.endClueGuessing(conn)
+		},
+	),
+	"change-name": defineCommand(
+		REJECT_INVALID_NAME,
+		func(r *Room, conn *connectionManager, p *changeNamePayload) {
+			r.setPlayerName(p.Name, conn)
+		},
+	),
+	"claim-role": defineCommand(
+		REJECT_INVALID_ROLE,
+		func(r *Room, conn *connectionManager, p *claimRolePayload) {
+			r.claimRole(p.role, conn)
+		},
+	),
+	"kick-player": defineCommand(
+		REJECT_NO_SUCH_PLAYER,
+		func(r *Room, conn *connectionManager, p *playerPayload) {
+			r.kickPlayer(int(*p.Player), false, conn)
+		},
+	),
+	"ban-player": defineCommand(
+		REJECT_NO_SUCH_PLAYER,
+		func(r *Room, conn *connectionManager, p *playerPayload) {
+			r.kickPlayer(int(*p.Player), true, conn)
+		},
+	),
+	"transfer-host": defineCommand(
+		REJECT_NO_SUCH_PLAYER,
+		func(r *Room, conn *connectionManager, p *playerPayload) {
+			r.transferHost(int(*p.Player), conn)
+		},
+	),
+	"update-access": defineCommand(
+		REJECT_INVALID_ACCESS,
+		func(r *Room, conn *connectionManager, p *updateAccessPayload) {
+			// Invite only rooms are unlisted unless asked otherwise, which is rejected.
+			public := r.Public && accessMode(p.Access) != ACCESS_INVITE
+			if p.Listing != "" {
+				public = p.Listing == "public"
+			}
+
+			r.updateAccess(accessMode(p.Access), p.Passcode, public, conn)
+		},
+	),
+	"update-settings": defineCommand(
+		REJECT_INVALID_SETTINGS,
+		func(r *Room, conn *connectionManager, p *updateSettingsPayload) {
+			r.updateSettings(p.settings(r.Settings), conn)
+		},
+	),
+}
+
+/**
+ * Decodes and validates a message received over the connection, then dispatches the
+ * command it carries. Clients that sent a request ID are sent an acknowledgement should
+ * the command not be rejected.
+ */
+func (r *Room) processMessage(message []byte, conn *connectionManager) {
+	if !r.isSeated(conn) {
+		r.Log.Info(fmt.Sprintf("ignored message from connection of removed player %s", conn.SessionID))
+		return
+	}
+
+	env := &envelope{}
+
+	err := json.Unmarshal(message, env)
+	if err != nil {
+		r.reject(conn, REJECT_MALFORMED, fmt.Sprintf("sent malformed message: %s", err.Error()))
+		return
+	}
+
+	conn.RequestID = env.ID
+	conn.Rejected = false
+	defer func() {
+		conn.RequestID = ""
+	}()
+
+	if env.Version == 0 {
+		env.Version = PROTOCOL_VERSION
+	}
+	if env.Version != PROTOCOL_VERSION {
+		r.reject(conn, REJECT_UNSUPPORTED_VERSION, fmt.Sprintf("sent message with unsupported protocol version: %d", env.Version))
+		return
+	}
+
+	spec, exists := commands[env.Cmd]
+	if !exists {
+		r.reject(conn, REJECT_UNKNOWN_COMMAND, fmt.Sprintf("sent unrecognised command: %s", env.Cmd))
+		return
+	}
+
+	data := []byte(env.Data)
+	if len(data) == 0 {
+		data = message
+	}
+
+	if rej := spec.dispatch(r, conn, data); rej != nil {
+		r.reject(conn, rej.code, rej.reason)
+		return
+	}
+
+	if env.ID != "" && !conn.Rejected {
+		r.acknowledge(conn, env.ID)
+	}
+}
@@ -0,0 +1,317 @@
+package room
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MatthewJM96/susnames/deck"
+	"github.com/MatthewJM96/susnames/session"
+	"github.com/spf13/viper"
+)
+
+type Registry struct {
+	Config  *viper.Viper
+	Log     *slog.Logger
+	Decks   *deck.Library
+	Cookies *session.CookieCodec
+	Store   Store // May be nil, in which case rooms don't survive a restart.
+
+	Rooms      map[string]*Room
+	RoomsMutex sync.RWMutex
+
+	Listeners      map[chan struct{}]struct{}
+	ListenersMutex sync.Mutex
+
+	stopReaper chan struct{}
+}
+
+func NewRegistry(
+	config *viper.Viper,
+	log *slog.Logger,
+	decks *deck.Library,
+	cookies *session.CookieCodec,
+	store Store,
+) *Registry {
+	return &Registry{
+		Config:     config,
+		Log:        log,
+		Decks:      decks,
+		Cookies:    cookies,
+		Store:      store,
+		Rooms:      make(map[string]*Room),
+		Listeners:  make(map[chan struct{}]struct{}),
+		stopReaper: make(chan struct{}),
+	}
+}
+
+func (reg *Registry) CreateRoom(host string) (*Room, error) {
+	reg.RoomsMutex.Lock()
+	defer reg.RoomsMutex.Unlock()
+
+	var name string
+
+	exists := true
+	for range 5 {
+		name = generateRoomName()
+
+		_, exists = reg.Rooms[name]
+		if !exists {
+			break
+		}
+	}
+	if exists {
+		return nil, fmt.Errorf("room name kept colliding, last tried: %s", name)
+	}
+
+	room := reg.newRoom(name, host)
+
+	reg.Rooms[name] = room
+
+	go room.run()
+
+	reg.Log.Info(fmt.Sprintf("created room: %s", name))
+
+	return room, nil
+}
+
+func (reg *Registry) newRoom(name string, host string) *Room {
+	room := newRoom(reg.Config, reg.Log, reg.Decks, reg.Cookies, name, host)
+	room.onChange = reg.notifyListeners
+	if reg.Store != nil {
+		room.writer = newSnapshotWriter(reg.Store, name, reg.Log)
+	}
+
+	return room
+}
+
+/**
+ * Restores every room saved to the registry's store, such as after a restart of the
+ * server. Rooms that can't be restored are logged and skipped.
+ */
+func (reg *Registry) Restore() error {
+	if reg.Store == nil {
+		return nil
+	}
+
+	snapshots, err := reg.Store.LoadAll()
+	if err != nil {
+		return err
+	}
+
+	reg.RoomsMutex.Lock()
+	defer reg.RoomsMutex.Unlock()
+
+	for name, snapshot := range snapshots {
+		if _, exists := reg.Rooms[name]; exists {
+			continue
+		}
+
+		games, err := reg.Store.LoadGames(name)
+		if err != nil {
+			reg.Log.Error(err.Error())
+			continue
+		}
+
+		room := reg.newRoom(name, "")
+
+		err = room.restore(snapshot, games)
+		if err != nil {
+			reg.Log.Error(err.Error())
+			continue
+		}
+
+		reg.Rooms[name] = room
+
+		go room.run()
+
+		reg.Log.Info(fmt.Sprintf("restored room: %s", name))
+	}
+
+	return nil
+}
+
+func (reg *Registry) GetRoom(name string) *Room {
+	reg.RoomsMutex.RLock()
+	defer reg.RoomsMutex.RUnlock()
+
+	return reg.Rooms[name]
+}
+
+/**
+ * Removes the room from the registry and closes it, disconnecting any remaining players.
+ */
+func (reg *Registry) DestroyRoom(name string) error {
+	err := reg.closeRoom(name)
+	if err != nil {
+		return err
+	}
+
+	if reg.Store != nil {
+		return reg.Store.Delete(name)
+	}
+
+	return nil
+}
+
+/**
+ * Removes the room from the registry and closes it, leaving any snapshot of it be.
+ */
+func (reg *Registry) closeRoom(name string) error {
+	reg.RoomsMutex.Lock()
+
+	room, exists := reg.Rooms[name]
+	if !exists {
+		reg.RoomsMutex.Unlock()
+		return fmt.Errorf("no room exists to destroy with name: %s", name)
+	}
+
+	delete(reg.Rooms, name)
+
+	reg.RoomsMutex.Unlock()
+
+	room.Close()
+
+	reg.notifyListeners()
+
+	return nil
+}
+
+/**
+ * Periodically destroys rooms that have had no players for longer than the configured
+ * "room_idle_timeout", checking every "room_reap_interval". Runs until the registry is
+ * closed, so should be run in its own goroutine.
+ */
+func (reg *Registry) Reap() {
+	ticker := time.NewTicker(reg.Config.GetDuration("room_reap_interval"))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reg.reapIdleRooms()
+		case <-reg.stopReaper:
+			return
+		}
+	}
+}
+
+func (reg *Registry) reapIdleRooms() {
+	idleTimeout := reg.Config.GetDuration("room_idle_timeout")
+
+	/**
+	 * Asking a room how long it has been idle waits on its event loop, so the rooms are
+	 * asked only once the registry is unlocked, that one busy room not hold up the rest.
+	 */
+
+	reg.RoomsMutex.RLock()
+
+	rooms := make([]*Room, 0, len(reg.Rooms))
+	for _, room := range reg.Rooms {
+		rooms = append(rooms, room)
+	}
+
+	reg.RoomsMutex.RUnlock()
+
+	idleRooms := make([]string, 0)
+	for _, room := range rooms {
+		if room.IdleFor() > idleTimeout {
+			idleRooms = append(idleRooms, room.Name)
+		}
+	}
+
+	for _, name := range idleRooms {
+		err := reg.DestroyRoom(name)
+		if err != nil {
+			reg.Log.Error(err.Error())
+			continue
+		}
+
+		reg.Log.Info(fmt.Sprintf("evicted room %s after being idle for over %s", name, idleTimeout.String()))
+	}
+}
+
+/**
+ * Stops the reaper and closes every room in the registry.
+ */
+func (reg *Registry) Close() {
+	close(reg.stopReaper)
+
+	reg.RoomsMutex.RLock()
+
+	names := make([]string, 0, len(reg.Rooms))
+	for name := range reg.Rooms {
+		names = append(names, name)
+	}
+
+	reg.RoomsMutex.RUnlock()
+
+	// Rooms are kept in the store, so that they may be restored when the server restarts.
+	for _, name := range names {
+		reg.closeRoom(name)
+	}
+}
+
+/**
+ * Gets the listings of every public room, ordered by name.
+ */
+func (reg *Registry) PublicRooms() []RoomListing {
+	reg.RoomsMutex.RLock()
+
+	rooms := make([]*Room, 0, len(reg.Rooms))
+	for _, room := range reg.Rooms {
+		rooms = append(rooms, room)
+	}
+
+	reg.RoomsMutex.RUnlock()
+
+	listings := make([]RoomListing, 0)
+	for _, room := range rooms {
+		listing, public := room.Listing()
+		if public {
+			listings = append(listings, listing)
+		}
+	}
+
+	sort.Slice(
+		listings,
+		func(i, j int) bool {
+			return listings[i].Name < listings[j].Name
+		},
+	)
+
+	return listings
+}
+
+/**
+ * Listens for changes to the listings of public rooms. The returned channel receives a
+ * value whenever listings may have changed, with changes made in quick succession
+ * coalesced. The returned function stops listening.
+ */
+func (reg *Registry) Listen() (<-chan struct{}, func()) {
+	listener := make(chan struct{}, 1)
+
+	reg.ListenersMutex.Lock()
+	reg.Listeners[listener] = struct{}{}
+	reg.ListenersMutex.Unlock()
+
+	return listener, func() {
+		reg.ListenersMutex.Lock()
+		delete(reg.Listeners, listener)
+		reg.ListenersMutex.Unlock()
+	}
+}
+
+func (reg *Registry) notifyListeners() {
+	reg.ListenersMutex.Lock()
+	defer reg.ListenersMutex.Unlock()
+
+	for listener := range reg.Listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+type rejectionCode string
+
+const (
+	REJECT_NOT_YOUR_TURN   rejectionCode = "not-your-turn"
+	REJECT_WRONG_ROLE      rejectionCode = "wrong-role"
+	REJECT_OUT_OF_VOTES    rejectionCode = "out-of-votes"
+	REJECT_ALREADY_ENDED   rejectionCode = "already-ended"
+	REJECT_ALREADY_VOTED   rejectionCode = "already-voted"
+	REJECT_NOT_VOTED       rejectionCode = "not-voted"
+	REJECT_CARD_SELECTED   rejectionCode = "card-selected"
+	REJECT_INVALID_CARD    rejectionCode = "invalid-card"
+	REJECT_NOT_TIED        rejectionCode = "not-tied"
+	REJECT_INVALID_CLUE    rejectionCode = "invalid-clue"
+	REJECT_INVALID_COUNT   rejectionCode = "invalid-count"
+	REJECT_CANNOT_START    rejectionCode = "cannot-start"
+	REJECT_INVALID_NAME    rejectionCode = "invalid-name"
+	REJECT_MALFORMED       rejectionCode = "malformed"
+	REJECT_UNKNOWN_COMMAND rejectionCode = "unknown-command"
+	REJECT_NOT_HOST        rejectionCode = "not-host"
+	REJECT_INVALID_ROLE    rejectionCode = "invalid-role"
+	REJECT_ROLE_TAKEN      rejectionCode = "role-taken"
+	REJECT_NO_SUCH_PLAYER  rejectionCode = "no-such-player"
+	REJECT_SELF_TARGET     rejectionCode = "self-target"
+	REJECT_INVALID_ACCESS  rejectionCode = "invalid-access"
+
+	REJECT_GAME_IN_PROGRESS    rejectionCode = "game-in-progress"
+	REJECT_MEETING_IN_PROGRESS rejectionCode = "meeting-in-progress"
+	REJECT_NO_MEETING          rejectionCode = "no-meeting"
+	REJECT_NO_MEETINGS_LEFT    rejectionCode = "no-meetings-left"
+	REJECT_INVALID_SETTINGS    rejectionCode = "invalid-settings"
+
+	REJECT_UNSUPPORTED_VERSION rejectionCode = "unsupported-version"
+)
+
+var rejectionMessages = map[rejectionCode]string{
+	REJECT_NOT_YOUR_TURN:   "It's not your turn.",
+	REJECT_WRONG_ROLE:      "Your role can't do that.",
+	REJECT_OUT_OF_VOTES:    "You're out of votes.",
+	REJECT_ALREADY_ENDED:   "You've already ended guessing.",
+	REJECT_ALREADY_VOTED:   "You've already voted for that card.",
+	REJECT_NOT_VOTED:       "You haven't voted for that card.",
+	REJECT_CARD_SELECTED:   "That card has already been selected.",
+	REJECT_INVALID_CARD:    "That card doesn't exist.",
+	REJECT_NOT_TIED:        "That card isn't one of the tied cards.",
+	REJECT_INVALID_CLUE:    "A clue must be a word and a count of at least zero.",
+	REJECT_INVALID_COUNT:   "The clue count must be a number.",
+	REJECT_CANNOT_START:    "The game couldn't be started.",
+	REJECT_INVALID_NAME:    "That name is too long.",
+	REJECT_MALFORMED:       "The server couldn't read that request.",
+	REJECT_UNKNOWN_COMMAND: "The server didn't understand that request.",
+	REJECT_NOT_HOST:        "Only the host can do that.",
+	REJECT_INVALID_ROLE:    "That role can't be chosen.",
+	REJECT_ROLE_TAKEN:      "Someone else has already claimed that role.",
+	REJECT_NO_SUCH_PLAYER:  "That player isn't in the room.",
+	REJECT_SELF_TARGET:     "You can't do that to yourself.",
+	REJECT_INVALID_ACCESS:  "A room can be open, need a passcode of at most 64 characters, or be invite only.",
+
+	REJECT_GAME_IN_PROGRESS:    "That can't be done while a game is in progress.",
+	REJECT_MEETING_IN_PROGRESS: "That can't be done during a meeting.",
+	REJECT_NO_MEETING:          "There's no meeting to vote in.",
+	REJECT_NO_MEETINGS_LEFT:    "No more meetings can be called this game.",
+	REJECT_INVALID_SETTINGS:    "Those settings aren't valid.",
+
+	REJECT_UNSUPPORTED_VERSION: "Your client is out of date, try refreshing the page.",
+}
+
+/**
+ * Rejects a command sent over the given connection, logging the reason for the
+ * rejection and letting the player know why nothing happened.
+ */
+func (r *Room) reject(conn *connectionManager, code rejectionCode, reason string) {
+	r.rejectWithMessage(conn, code, reason, rejectionMessages[code])
+}
+
+/**
+ * Rejects a command as with reject, but tells the player the given message rather than
+ * the generic message for the rejection code.
+ */
+func (r *Room) rejectWithMessage(conn *connectionManager, code rejectionCode, reason string, message string) {
+	r.Log.Warn(
+		fmt.Sprintf(
+			"(%s, %s) %s",
+			conn.Player.SessionID,
+			conn.Player.Name,
+			reason,
+		),
+	)
+
+	conn.Rejected = true
+
+	if conn.Format == FORMAT_JSON {
+		conn.send(
+			r.marshalJSONMessage(
+				"error",
+				conn.RequestID,
+				jsonRejection{string(code), message},
+			),
+		)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+
+	components.Toast(string(code), message, conn.RequestID).Render(context.Background(), buf)
+
+	conn.send(buf.Bytes())
+}
+
+/**
+ * Acknowledges that the command with the given request ID was accepted.
+ */
+func (r *Room) acknowledge(conn *connectionManager, requestID string) {
+	if conn.Format == FORMAT_JSON {
+		conn.send(r.marshalJSONMessage("ack", requestID, nil))
+		return
+	}
+
+	buf := new(bytes.Buffer)
+
+	components.Ack(requestID).Render(context.Background(), buf)
+
+	conn.send(buf.Bytes())
+}
+
+/**
+ * Rejects a vote or unvote that the grid refused.
+ */
+func (r *Room) rejectCardError(conn *connectionManager, err error) {
+	if errors.Is(err, grid.ErrCardSelected) {
+		r.reject(conn, REJECT_CARD_SELECTED, err.Error())
+	} else {
+		r.reject(conn, REJECT_INVALID_CARD, err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// Fewest players, not counting spectators, with which a game may be played.
+const MIN_PLAYERS = 3
+
+var ErrTooFewPlayers = errors.New("not enough players")
+var ErrTooManySpymasters = errors.New("more than one spymaster")
+var ErrTooManyCounterspies = errors.New("too many counterspies")
+
+/**
+ * Gets the default number of counterspies for a game in which the given number of
+ * players, not including the spymaster, are spies. Counterspies must always be fewer
+ * than the loyal spies.
+ */
+func defaultCounterspies(spies int) int {
+	return max((spies-1)/2, 0)
+}
+
+/**
+ * Decides the role of each player in a game from the roles they claimed in the lobby.
+ * Should no player have claimed to be the spymaster, one of the spies is chosen at
+ * random, after which the given number of counterspies are chosen at random from the
+ * remaining spies. The number of counterspies may be AUTO to have a default chosen.
+ *
+ * The roles are returned in the same order as the claims they were decided from.
+ */
+func decideRoles(claims []PlayerRole, counterspies int, rnd *rand.Rand) ([]PlayerRole, error) {
+	roles := make([]PlayerRole, len(claims))
+
+	spymasters := 0
+	spies := make([]int, 0, len(claims))
+	for index, claim := range claims {
+		roles[index] = claim
+
+		if claim == SPYMASTER {
+			spymasters += 1
+		} else if claim != SPECTATOR {
+			roles[index] = SPY
+			spies = append(spies, index)
+		}
+	}
+
+	if spymasters > 1 {
+		return nil, fmt.Errorf("%w: %d players claimed to be the spymaster", ErrTooManySpymasters, spymasters)
+	}
+
+	if spymasters+len(spies) < MIN_PLAYERS {
+		return nil, fmt.Errorf(
+			"%w: at least %d are needed but only %d are playing",
+			ErrTooFewPlayers,
+			MIN_PLAYERS,
+			spymasters+len(spies),
+		)
+	}
+
+	/**
+	 * Choose a spymaster from among the spies if no player has claimed the role.
+	 */
+
+	if spymasters == 0 {
+		idx := rnd.Intn(len(spies))
+
+		roles[spies[idx]] = SPYMASTER
+		spies = append(spies[:idx], spies[idx+1:]...)
+	}
+
+	/**
+	 * Choose counterspies, of which there must be fewer than there are loyal spies.
+	 */
+
+	if counterspies == AUTO {
+		counterspies = defaultCounterspies(len(spies))
+	}
+
+	if counterspies < 0 || counterspies*2 >= len(spies) {
+		return nil, fmt.Errorf(
+			"%w: %d spies can have at most %d counterspies among them",
+			ErrTooManyCounterspies,
+			len(spies),
+			defaultCounterspies(len(spies)),
+		)
+	}
+
+	rnd.Shuffle(
+		len(spies),
+		func(i, j int) {
+			spies[i], spies[j] = spies[j], spies[i]
+		},
+	)
+
+	for _, index := range spies[:counterspies] {
+		roles[index] = COUNTERSPY
+	}
+
+	return roles, nil
+}
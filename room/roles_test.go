@@ -0,0 +1,166 @@
+package room
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func repeatRole(role PlayerRole, count int) []PlayerRole {
+	roles := make([]PlayerRole, count)
+	for index := range roles {
+		roles[index] = role
+	}
+
+	return roles
+}
+
+func TestDecideRoles(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       []PlayerRole
+		setting      int // Number of counterspies asked for, or AUTO.
+		err          error
+		spies        int
+		counterspies int
+	}{
+		{
+			name:    "too few players",
+			claims:  repeatRole(SPY, MIN_PLAYERS-1),
+			setting: AUTO,
+			err:     ErrTooFewPlayers,
+		},
+		{
+			name:    "just enough players",
+			claims:  repeatRole(SPY, MIN_PLAYERS),
+			setting: AUTO,
+			spies:   MIN_PLAYERS - 1,
+		},
+		{
+			name:    "spectators don't count towards players",
+			claims:  append(repeatRole(SPY, MIN_PLAYERS-1), SPECTATOR, SPECTATOR),
+			setting: AUTO,
+			err:     ErrTooFewPlayers,
+		},
+		{
+			name:    "spectators keep watching",
+			claims:  append(repeatRole(SPY, MIN_PLAYERS), SPECTATOR),
+			setting: AUTO,
+			spies:   MIN_PLAYERS - 1,
+		},
+		{
+			name:    "claimed spymaster counts towards players",
+			claims:  append(repeatRole(SPY, MIN_PLAYERS-1), SPYMASTER),
+			setting: AUTO,
+			spies:   MIN_PLAYERS - 1,
+		},
+		{
+			name:    "two spymasters",
+			claims:  append(repeatRole(SPY, 4), SPYMASTER, SPYMASTER),
+			setting: AUTO,
+			err:     ErrTooManySpymasters,
+		},
+		{
+			name:         "default counterspies",
+			claims:       repeatRole(SPY, 7),
+			setting:      AUTO,
+			spies:        4,
+			counterspies: 2,
+		},
+		{
+			name:         "chosen counterspies",
+			claims:       repeatRole(SPY, 7),
+			setting:      1,
+			spies:        5,
+			counterspies: 1,
+		},
+		{
+			name:    "no counterspies",
+			claims:  repeatRole(SPY, 7),
+			setting: 0,
+			spies:   6,
+		},
+		{
+			name:    "as many counterspies as loyal spies",
+			claims:  repeatRole(SPY, 5),
+			setting: 2,
+			err:     ErrTooManyCounterspies,
+		},
+		{
+			name:    "negative counterspies",
+			claims:  repeatRole(SPY, 5),
+			setting: -2,
+			err:     ErrTooManyCounterspies,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				for seed := range int64(20) {
+					roles, err := decideRoles(test.claims, test.setting, rand.New(rand.NewSource(seed)))
+					if test.err != nil {
+						if !errors.Is(err, test.err) {
+							t.Fatalf("expected error %v, got %v", test.err, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+
+					counts := make(map[PlayerRole]int)
+					for index, role := range roles {
+						counts[role] += 1
+
+						if test.claims[index] == SPECTATOR && role != SPECTATOR {
+							t.Errorf("spectator %d was given role %s", index, getPlayerRoleClass(role))
+						}
+						if test.claims[index] == SPYMASTER && role != SPYMASTER {
+							t.Errorf("claimed spymaster %d was given role %s", index, getPlayerRoleClass(role))
+						}
+					}
+
+					if counts[SPYMASTER] != 1 {
+						t.Errorf("expected exactly one spymaster, got %d", counts[SPYMASTER])
+					}
+					if counts[SPY] != test.spies {
+						t.Errorf("expected %d spies, got %d", test.spies, counts[SPY])
+					}
+					if counts[COUNTERSPY] != test.counterspies {
+						t.Errorf("expected %d counterspies, got %d", test.counterspies, counts[COUNTERSPY])
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestDefaultCounterspies(t *testing.T) {
+	tests := []struct {
+		spies        int
+		counterspies int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, 1},
+		{4, 1},
+		{5, 2},
+		{6, 2},
+		{9, 4},
+	}
+
+	for _, test := range tests {
+		counterspies := defaultCounterspies(test.spies)
+		if counterspies != test.counterspies {
+			t.Errorf("expected %d counterspies among %d spies, got %d", test.counterspies, test.spies, counterspies)
+		}
+
+		// Counterspies must always be fewer than the loyal spies.
+		if test.spies > 0 && counterspies >= test.spies-counterspies {
+			t.Errorf("%d counterspies among %d spies outnumber the loyal spies", counterspies, test.spies)
+		}
+	}
+}
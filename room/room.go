@@ -1,220 +1,523 @@
 package room
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
-	"math"
 	"net/http"
-	"strconv"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/MatthewJM96/susnames/deck"
 	"github.com/MatthewJM96/susnames/grid"
+	"github.com/MatthewJM96/susnames/session"
 	"github.com/MatthewJM96/susnames/util"
 	"github.com/spf13/viper"
 )
 
 type Room struct {
-	Config *viper.Viper
-	Log    *slog.Logger
+	Config  *viper.Viper
+	Log     *slog.Logger
+	Decks   *deck.Library
+	Cookies *session.CookieCodec
 
 	Name string
+	Host string // Session ID of the player who created the room.
+
+	Settings   Settings
+	CustomDeck *deck.Deck
 
 	Players      map[string]*Player
-	PlayersMutex sync.Mutex
-
-	GameStateMutex sync.Mutex
-	Started        bool
-	Spies          int // Note that this includes the number of counterspies.
-	Counterspies   int
-	Turn           PlayerRole
-	Clue           string
-	ClueMatches    int
-	Grid           *grid.Grid
-	VoteTimer      *time.Timer
-	VoteEndVotes   int
-	EndVotingOn    int
+	NextPlayerID int
+	EmptySince   time.Time // When the last player left, or the room was created.
+
+	Public      bool // Whether the room is listed for anyone to find and join.
+	Access      accessMode
+	Passcode    []byte // Hash of the passcode players must give to join, if one is set.
+	AccessEpoch int    // Bumped on each change in access, revoking invites issued before.
+	Admitted    map[string]struct{}
+	Kicked      map[string]time.Time // When each recently kicked session was kicked.
+	Banned      map[string]struct{}
+
+	Closed       bool
+	Started      bool
+	Finished     bool
+	Winner       PlayerRole
+	Spies        int // Number of loyal spies, not including counterspies.
+	Counterspies int // Number of counterspies in the ongoing game.
+	Turn         PlayerRole
+	Clue         string
+	ClueMatches  int
+	Grid         *grid.Grid
+	Games        []*GameLog // Logs of recent games, the last being that of the current game.
+	GamesStarted int
+	VoteTimer    *time.Timer
+	VoteRound    int
+	VoteEndVotes int
+	EndVotingOn  int   // Number of spies needed to end voting in the ongoing game.
+	TiedCards    []int // Cards tied for the most votes, while the tie is being broken.
+	Revealed     []int // Cards revealed in the current round of voting, in order.
+
+	Meeting        *Meeting // Emergency meeting under way in the ongoing game, if any.
+	MeetingsCalled int      // Number of emergency meetings called in the ongoing game.
+
+	events   chan func()
+	done     chan struct{}
+	stopOnce sync.Once
+	onChange func()
+
+	writer        *snapshotWriter // Nil should the room not be kept in a store.
+	persistDue    chan struct{}
+	persistTimer  *time.Timer
+	lastSnapshot  []byte
+	lastGamesSpan [2]int // Span of the finished games last saved.
 }
 
-var rooms map[string]*Room = make(map[string]*Room)
-
-const VOTE_TIME = 30 * time.Second
-
 func generateRoomName() string {
 	return util.GenerateRandomThreePartName()
 }
 
-func CreateRoom(config *viper.Viper, log *slog.Logger) (*Room, error) {
-	var name string
-
-	exists := true
-	for range 5 {
-		name = generateRoomName()
-
-		_, exists = rooms[name]
-	}
-	if exists {
-		return nil, fmt.Errorf("room name kept colliding, last tried: %s", name)
-	}
-
-	log.Info(fmt.Sprintf("created room: %s", name))
-
-	room := &Room{
+func newRoom(
+	config *viper.Viper,
+	log *slog.Logger,
+	decks *deck.Library,
+	cookies *session.CookieCodec,
+	name string,
+	host string,
+) *Room {
+	return &Room{
 		Config:       config,
 		Log:          log,
+		Decks:        decks,
+		Cookies:      cookies,
 		Name:         name,
+		Host:         host,
+		Settings:     defaultSettings(config),
 		Players:      make(map[string]*Player),
+		Access:       ACCESS_OPEN,
+		Admitted:     make(map[string]struct{}),
+		Kicked:       make(map[string]time.Time),
+		Banned:       make(map[string]struct{}),
+		EmptySince:   time.Now(),
 		Started:      false,
+		Finished:     false,
 		Spies:        0,
-		Counterspies: -1,
-		EndVotingOn:  -1,
+		Counterspies: 0,
+		EndVotingOn:  0,
+
+		events:     make(chan func(), EVENT_QUEUE_SIZE),
+		done:       make(chan struct{}),
+		persistDue: make(chan struct{}),
+	}
+}
+
+/**
+ * Closes the room, ending any ongoing game and disconnecting all of its players. A closed
+ * room will not accept new players.
+ */
+func (r *Room) Close() {
+	r.call(
+		func() {
+			// Save any changes yet to be saved, so that none are lost on shutting down.
+			r.persist()
+
+			r.Closed = true
+			if r.VoteTimer != nil {
+				r.VoteTimer.Stop()
+			}
+
+			for _, player := range r.Players {
+				if player.DisconnectTimer != nil {
+					player.DisconnectTimer.Stop()
+				}
+			}
+
+			r.broadcastMessage(
+				func(_ *Player, _ messageFormat) ([]byte, bool) {
+					return []byte("close"), false
+				},
+			)
+
+			r.stop()
+
+			r.Log.Info(fmt.Sprintf("closed room: %s", r.Name))
+		},
+	)
+
+	if r.writer != nil {
+		r.writer.close()
 	}
+}
+
+/**
+ * Reports how long the room has been without any players, zero if it has players.
+ */
+func (r *Room) IdleFor() time.Duration {
+	var idleFor time.Duration
+
+	r.call(
+		func() {
+			if r.connectedPlayerCount() > 0 {
+				return
+			}
 
-	rooms[name] = room
+			idleFor = time.Since(r.EmptySince)
+		},
+	)
 
-	return room, nil
+	return idleFor
 }
 
-func GetRoom(name string) *Room {
-	return rooms[name]
+/**
+ * Reports whether a game has started in the room and is yet to finish.
+ */
+func (r *Room) inProgress() bool {
+	return r.Started && !r.Finished
 }
 
-func (r *Room) assignRoles() {
-	r.PlayersMutex.Lock()
+func (r *Room) IsHost(sessionID string) bool {
+	isHost := false
 
-	/**
-	 * Look for spymaster, and count number of players who will be playing.
-	 */
+	r.call(
+		func() {
+			isHost = r.Host == sessionID
+		},
+	)
 
-	foundSpymaster := false
-	r.Spies = 0
+	return isHost
+}
+
+/**
+ * Sets a custom deck for use by this room, selecting it for the next game.
+ */
+func (r *Room) SetCustomDeck(deck *deck.Deck) {
+	r.post(
+		func() {
+			r.CustomDeck = deck
+
+			r.Log.Info(fmt.Sprintf("room %s now using custom deck %s", r.Name, deck.Name))
+
+			if r.inProgress() {
+				return
+			}
+
+			r.Settings.Deck = deck.Name
+
+			r.broadcastSettings(context.Background())
+		},
+	)
+}
+
+/**
+ * Gets the form for uploading a custom deck, which only the host may use. JSON clients
+ * upload decks without it, and so aren't sent it.
+ */
+func (r *Room) makeDeckUploader(ctx context.Context, player *Player, format messageFormat) ([]byte, bool) {
+	if format == FORMAT_JSON {
+		return nil, true
+	}
+
+	buf := new(bytes.Buffer)
+
+	if r.Host == player.SessionID {
+		components.DeckUploader(r.Name).Render(ctx, buf)
+	} else {
+		components.EmptyDeckUploader().Render(ctx, buf)
+	}
+
+	return buf.Bytes(), false
+}
+
+/**
+ * Sends the form for uploading a custom deck to the host, clearing it for everyone else.
+ */
+func (r *Room) broadcastDeckUploader(ctx context.Context) {
+	r.broadcastMessage(
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			return r.makeDeckUploader(ctx, player, format)
+		},
+	)
+}
+
+/**
+ * Finds the deck of the given name available to this room, preferring the room's custom
+ * deck should it share a name with a deck in the library.
+ */
+func (r *Room) findDeck(name string) *deck.Deck {
+	if r.CustomDeck != nil && r.CustomDeck.Name == name {
+		return r.CustomDeck
+	}
+
+	return r.Decks.Get(name)
+}
+
+/**
+ * Assigns every player their role for a new game from the roles they claimed in the
+ * lobby, so that roles from a previous game in this room don't carry over to the next.
+ * Should the players not be able to play a game with the room's settings, no player's
+ * role is changed.
+ */
+func (r *Room) assignRoles() error {
+	players := make([]*Player, 0, len(r.Players))
+	claims := make([]PlayerRole, 0, len(r.Players))
 	for _, player := range r.Players {
-		if player.Role == SPYMASTER {
-			foundSpymaster = true
-		} else if player.Role == SPY {
+		players = append(players, player)
+		claims = append(claims, player.ClaimedRole)
+	}
+
+	util.RefreshRandSeed()
+
+	roles, err := decideRoles(claims, r.Settings.Counterspies, util.Rnd)
+	if err != nil {
+		return err
+	}
+
+	r.Spies = 0
+	r.Counterspies = 0
+	for index, player := range players {
+		player.Role = roles[index]
+		player.Votes = 0
+		player.EndedGuessing = false
+		player.Ejected = false
+		player.Score = 0
+
+		if player.Role == SPY {
 			r.Spies += 1
+		} else if player.Role == COUNTERSPY {
+			r.Counterspies += 1
 		}
 	}
 
-	/**
-	 * Assign a default number of counterspies if none has been set.
-	 */
+	return nil
+}
 
-	if r.Counterspies == -1 {
-		r.Counterspies = int(math.Floor(float64(r.Spies-1) / 2.0))
+/**
+ * Reassigns roles after a player has left an ongoing game. A departed spymaster is
+ * replaced by a random loyal spy. Should no loyal spies remain, the spies can no longer
+ * win and so the counterspies are declared the winners.
+ */
+func (r *Room) reassignRoles(departed *Player) {
+	loyalSpies := make([]*Player, 0)
+	for _, player := range r.Players {
+		if player.Role == SPY && !player.Ejected {
+			loyalSpies = append(loyalSpies, player)
+		}
 	}
 
-	util.RefreshRandSeed()
+	if departed.Role == SPYMASTER && len(loyalSpies) > 0 {
+		util.RefreshRandSeed()
 
-	/**
-	 * Assign spymaster if no player has claimed the role.
-	 */
+		spymaster := loyalSpies[util.Rnd.Intn(len(loyalSpies))]
+		r.withdrawVotes(spymaster)
 
-	if !foundSpymaster {
-		idx := util.Rnd.Intn(r.Spies)
-		for _, player := range r.Players {
-			if player.Role != SPY {
-				continue
-			}
+		spymaster.Role = SPYMASTER
 
-			if idx == 0 {
-				player.Role = SPYMASTER
-				break
-			}
+		r.record(GameEvent{Type: ROLE_CHANGED, Player: eventPlayer(spymaster)})
+
+		r.Log.Info(
+			fmt.Sprintf(
+				"(%s, %s) promoted to spymaster in room %s after (%s, %s) left",
+				spymaster.SessionID,
+				spymaster.Name,
+				r.Name,
+				departed.SessionID,
+				departed.Name,
+			),
+		)
+	}
+
+	r.countSpies()
+
+	if r.Spies == 0 {
+		r.Finished = true
+		r.Winner = COUNTERSPY
+
+		r.recordWin()
+
+		if r.VoteTimer != nil {
+			r.VoteTimer.Stop()
+		}
 
-			idx -= 1
+		r.Log.Info(fmt.Sprintf("game in room %s won by counterspies as no loyal spies remain", r.Name))
+
+		return
+	}
+
+	r.limitEndVotingOn()
+}
+
+/**
+ * Counts the loyal spies and counterspies yet in the game.
+ */
+func (r *Room) countSpies() {
+	r.Spies = 0
+	r.Counterspies = 0
+	for _, player := range r.Players {
+		if player.Ejected {
+			continue
+		}
+
+		if player.Role == SPY {
+			r.Spies += 1
+		} else if player.Role == COUNTERSPY {
+			r.Counterspies += 1
 		}
-		r.Spies -= 1
 	}
+}
 
-	/**
-	 * Assign counterspies.
-	 */
+/**
+ * Withdraws the player's votes for cards and any vote they made to end guessing, as
+ * when they leave the game or take up another role in it.
+ */
+func (r *Room) withdrawVotes(player *Player) {
+	r.Grid.RemoveVotes(player.SessionID)
 
-	for range r.Counterspies {
-		idx := util.Rnd.Intn(r.Spies)
-		for _, player := range r.Players {
-			if player.Role != SPY {
-				continue
-			}
+	if player.EndedGuessing {
+		r.VoteEndVotes -= 1
+	}
 
-			if idx == 0 {
-				player.Role = COUNTERSPY
-				break
-			}
+	player.Votes = 0
+	player.EndedGuessing = false
+}
 
-			idx -= 1
+/**
+ * Makes sure enough players remain for the spies to end voting early.
+ */
+func (r *Room) limitEndVotingOn() {
+	voters := 0
+	for _, player := range r.Players {
+		if (player.Role == SPY || player.Role == COUNTERSPY) && !player.Ejected {
+			voters += 1
 		}
-		r.Spies -= 1
 	}
 
-	r.PlayersMutex.Unlock()
+	r.EndVotingOn = min(r.EndVotingOn, voters)
+}
+
+/**
+ * Determines if the game has been won by either the spies or the counterspies. Spies win
+ * once every spy target has been revealed, counterspies win once enough counterspy
+ * targets have been revealed - by default all of them. Should the spies reveal their last
+ * target in the same round as the counterspies, the spies take the win.
+ */
+func (r *Room) evaluateWinConditions() bool {
+	if r.Grid.CountSelectedOfType(grid.SPY_TARGET) >= r.Grid.CountType(grid.SPY_TARGET) {
+		r.Winner = SPY
+	} else if r.Grid.CountSelectedOfType(grid.COUNTERSPY_TARGET) >= r.counterspyWinReveals() {
+		r.Winner = COUNTERSPY
+	} else {
+		return false
+	}
+
+	r.Finished = true
+
+	return true
+}
+
+func (r *Room) counterspyWinReveals() int {
+	if r.Settings.CounterspyWinReveals == AUTO {
+		return r.Grid.CountType(grid.COUNTERSPY_TARGET)
+	}
+
+	return r.Settings.CounterspyWinReveals
 }
 
-func (r *Room) startGame() {
-	r.GameStateMutex.Lock()
+func (r *Room) startGame(conn *connectionManager) {
+	if r.Host != conn.SessionID {
+		r.reject(conn, REJECT_NOT_HOST, "tried to start game but is not the host")
+		return
+	}
+
+	reason := r.validateSettings(r.Settings, r.playingPlayerCount())
+	if reason != "" {
+		r.rejectWithMessage(conn, REJECT_CANNOT_START, fmt.Sprintf("tried to start game with invalid settings: %s", reason), "Can't start: "+reason+".")
+		return
+	}
+
+	deck := r.findDeck(r.Settings.Deck)
+
+	grid, err := grid.CreateGrid(r.Settings.SpyCards, r.Settings.CounterspyCards, deck)
+	if err != nil {
+		r.reject(conn, REJECT_CANNOT_START, fmt.Sprintf("tried to start game but grid could not be created: %s", err.Error()))
+		return
+	}
+
+	err = r.assignRoles()
+	if err != nil {
+		r.rejectWithMessage(conn, REJECT_CANNOT_START, fmt.Sprintf("tried to start game but roles could not be assigned: %s", err.Error()), "Can't start: "+err.Error()+".")
+		return
+	}
 
 	r.Started = true
+	r.Finished = false
+	r.Winner = SPECTATOR
 	r.Turn = SPYMASTER
-	r.Grid = grid.CreateGridFromWords(
-		12,
-		6,
-		[25]string{
-			"relinquish", "genuine", "formula", "gain", "established", "development",
-			"long", "personality", "package", "reveal", "premium", "carve", "authority",
-			"blast", "compromise", "acid", "video", "live", "eject", "redundancy",
-			"announcement", "tear", "depressed", "cunning", "child",
-		},
-	)
+	r.Grid = grid
 	r.Clue = ""
 	r.ClueMatches = 0
 	r.VoteEndVotes = 0
+	r.TiedCards = nil
+	r.Revealed = nil
+	r.MeetingsCalled = 0
+
+	if r.Meeting != nil {
+		r.Meeting.Timer.Stop()
+		r.Meeting = nil
+	}
 
-	r.assignRoles()
+	r.beginGameLog()
+	r.recordGameStarted()
+
+	if r.VoteTimer != nil {
+		r.VoteTimer.Stop()
+	}
 
 	// TODO(Matthew): is this a satisfying way of doing this?
-	if r.EndVotingOn == -1 {
+	if r.Settings.EndVotingOn == AUTO {
 		r.EndVotingOn = min(r.Counterspies+2, r.Spies)
+	} else {
+		r.EndVotingOn = min(r.Settings.EndVotingOn, r.Spies+r.Counterspies)
 	}
 
-	r.GameStateMutex.Unlock()
-
 	r.broadcastGameState(context.Background())
+	r.broadcastSettings(context.Background())
 }
 
 func (r *Room) endClueGuessing(conn *connectionManager) {
-	r.GameStateMutex.Lock()
+	if r.Meeting != nil {
+		r.reject(conn, REJECT_MEETING_IN_PROGRESS, "tried to stop guessing during a meeting")
+		return
+	}
 
-	if r.Turn != SPY {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to stop guessing while it wasn't the Spies' go",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+	if r.Finished || r.Turn != SPY || r.awaitingTieBreak() {
+		r.reject(conn, REJECT_NOT_YOUR_TURN, "tried to stop guessing while it wasn't the Spies' go")
 		return
 	}
 
-	if conn.Player.Role != SPY && conn.Player.Role != COUNTERSPY {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to stop guessing but is not a Spy",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+	if (conn.Player.Role != SPY && conn.Player.Role != COUNTERSPY) || conn.Player.Ejected {
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to stop guessing but is not a Spy")
 		return
 	}
 
+	if conn.Player.EndedGuessing {
+		r.reject(conn, REJECT_ALREADY_ENDED, "tried to stop guessing but had already")
+		return
+	}
+
+	conn.Player.EndedGuessing = true
+
+	r.record(GameEvent{Type: GUESSING_ENDED, Player: eventPlayer(conn.Player)})
+
 	r.VoteEndVotes += 1
 	if r.VoteEndVotes >= r.EndVotingOn {
 		r.Log.Info("voting closed by players")
 
 		r.VoteTimer.Stop()
 
-		go r.endVoting()
+		r.endVoting()
 	} else {
 		r.Log.Info(
 			fmt.Sprintf(
@@ -225,32 +528,21 @@ func (r *Room) endClueGuessing(conn *connectionManager) {
 			),
 		)
 	}
-
-	r.GameStateMutex.Unlock()
 }
 
 func (r *Room) suggestClue(clue string, matches int, conn *connectionManager) {
-	r.GameStateMutex.Lock()
+	if r.Meeting != nil {
+		r.reject(conn, REJECT_MEETING_IN_PROGRESS, "tried to suggest clue during a meeting")
+		return
+	}
 
-	if r.Turn != SPYMASTER {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to suggest clue while it wasn't the Spymaster's go",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+	if r.Finished || r.Turn != SPYMASTER {
+		r.reject(conn, REJECT_NOT_YOUR_TURN, "tried to suggest clue while it wasn't the Spymaster's go")
 		return
 	}
 
 	if conn.Player.Role != SPYMASTER {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to suggest clue but is not the Spymaster",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to suggest clue but is not the Spymaster")
 		return
 	}
 
@@ -258,6 +550,8 @@ func (r *Room) suggestClue(clue string, matches int, conn *connectionManager) {
 	r.Clue = clue
 	r.ClueMatches = matches
 
+	r.record(GameEvent{Type: CLUE_SUGGESTED, Player: eventPlayer(conn.Player), Clue: clue, Count: matches})
+
 	r.Log.Info(
 		fmt.Sprintf(
 			"(%s, %s) suggested clue (%s, %d)",
@@ -268,13 +562,6 @@ func (r *Room) suggestClue(clue string, matches int, conn *connectionManager) {
 		),
 	)
 
-	r.VoteEndVotes = 0
-	r.Grid.ResetVote()
-
-	for _, player := range r.Players {
-		player.Votes = 0
-	}
-
 	if r.VoteTimer != nil && r.VoteTimer.Stop() {
 		r.Log.Error(
 			fmt.Sprintf(
@@ -285,73 +572,144 @@ func (r *Room) suggestClue(clue string, matches int, conn *connectionManager) {
 		)
 	}
 
-	r.Log.Info(fmt.Sprintf("voting open, ends in %s", VOTE_TIME.String()))
+	r.openVoting()
+
+	r.broadcastClue(context.Background())
+}
+
+/**
+ * Starts the timer for the current round of voting, after which it will be ended should
+ * the players not end it themselves.
+ */
+func (r *Room) startVoteTimer() {
+	r.Log.Info(fmt.Sprintf("voting open, ends in %s", r.Settings.VoteTime.String()))
 
+	round := r.VoteRound
 	r.VoteTimer = time.AfterFunc(
-		VOTE_TIME,
+		r.Settings.VoteTime,
 		func() {
-			r.Log.Info("voting closed by timeout")
-			r.endVoting()
+			r.post(
+				func() {
+					r.endVotingOnTimeout(round)
+				},
+			)
 		},
 	)
+}
 
-	r.GameStateMutex.Unlock()
+/**
+ * Ends the given round of voting, unless it has already been ended by the players.
+ */
+func (r *Room) endVotingOnTimeout(round int) {
+	if round != r.VoteRound || r.Turn != SPY || r.Meeting != nil {
+		return
+	}
 
-	r.broadcastClue(context.Background())
+	r.Log.Info("voting closed by timeout")
+
+	r.endVoting()
+}
+
+/**
+ * Ends the round of voting should enough spies have ended guessing, as may come to pass
+ * once fewer players remain to vote. Returns true if voting was ended.
+ */
+func (r *Room) endVotingIfDue() bool {
+	if r.Finished || r.Turn != SPY || r.Meeting != nil || r.awaitingTieBreak() {
+		return false
+	}
+
+	if r.VoteEndVotes == 0 || r.VoteEndVotes < r.EndVotingOn {
+		return false
+	}
+
+	r.Log.Info("voting closed by players")
+
+	if r.VoteTimer != nil {
+		r.VoteTimer.Stop()
+	}
+
+	r.endVoting()
+
+	return true
 }
 
 func (r *Room) endVoting() {
-	r.GameStateMutex.Lock()
+	// Voting is paused during a meeting, and picks up again once it has ended.
+	if !r.Started || r.Finished || r.Meeting != nil {
+		return
+	}
 
-	r.Grid.EvaluateVote()
-	r.Turn = SPYMASTER
+	if r.awaitingTieBreak() {
+		r.Log.Info("spymaster did not break tie in time")
 
-	r.GameStateMutex.Unlock()
+		tiedCards := r.TiedCards
+		r.TiedCards = nil
 
-	r.broadcastGameState(context.Background())
+		r.revealRandomCard(tiedCards, REVEAL_TIE_RANDOM)
+	} else if r.settleVote() {
+		return
+	}
+
+	r.continueReveals()
 }
 
-func (r *Room) voteCard(cardIndex int, conn *connectionManager) {
-	r.GameStateMutex.Lock()
-	defer r.GameStateMutex.Unlock()
+/**
+ * Ends the spies' turn once the round of voting has been settled, passing the turn to
+ * the spymaster should neither side have won.
+ */
+func (r *Room) endVotingRound() {
+	r.TiedCards = nil
+	r.Revealed = nil
+	r.Turn = SPYMASTER
 
-	if r.Turn != SPY {
-		r.Log.Error(
+	if r.evaluateWinConditions() {
+		r.recordWin()
+
+		r.Log.Info(
 			fmt.Sprintf(
-				"(%s, %s) tried to vote for a card while it wasn't the Spies' go",
-				conn.Player.SessionID,
-				conn.Player.Name,
+				"game in room %s won by %ss",
+				r.Name,
+				getPlayerRoleClass(r.Winner),
 			),
 		)
+
+		r.broadcastSettings(context.Background())
+	}
+
+	r.broadcastGameState(context.Background())
+}
+
+func (r *Room) voteCard(cardIndex int, conn *connectionManager) {
+	if r.Meeting != nil {
+		r.reject(conn, REJECT_MEETING_IN_PROGRESS, "tried to vote for a card during a meeting")
 		return
 	}
 
-	if conn.Player.Role != SPY && conn.Player.Role != COUNTERSPY {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to vote for a card but is not a Spy or Counterspy",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+	if r.Finished || r.Turn != SPY || r.awaitingTieBreak() {
+		r.reject(conn, REJECT_NOT_YOUR_TURN, "tried to vote for a card while it wasn't the Spies' go")
 		return
 	}
 
-	if conn.Player.Votes >= r.ClueMatches+1 {
-		r.Log.Info(
-			fmt.Sprintf(
-				"(%s, %s) tried to vote for card %d but had hit max votes",
-				conn.Player.SessionID,
-				conn.Player.Name,
-				cardIndex,
-			),
-		)
+	if (conn.Player.Role != SPY && conn.Player.Role != COUNTERSPY) || conn.Player.Ejected {
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to vote for a card but is not a Spy or Counterspy")
+		return
+	}
+
+	if conn.Player.Votes >= r.voteLimit() {
+		r.reject(conn, REJECT_OUT_OF_VOTES, fmt.Sprintf("tried to vote for card %d but had hit max votes", cardIndex))
+		return
+	}
+
+	if r.TiedCards != nil && !slices.Contains(r.TiedCards, cardIndex) {
+		r.reject(conn, REJECT_NOT_TIED, fmt.Sprintf("tried to vote for card %d in a runoff it was not part of", cardIndex))
 		return
 	}
 
 	voted, err := r.Grid.VoteCardAtIndex(cardIndex, conn.Player.SessionID)
 	if err != nil {
-		r.Log.Error(err.Error())
+		r.rejectCardError(conn, err)
+		return
 	}
 
 	if voted {
@@ -366,48 +724,39 @@ func (r *Room) voteCard(cardIndex int, conn *connectionManager) {
 
 		conn.Player.Votes += 1
 
-		// TODO(Matthew): broadcast to voter a card change to reflect accepted vote.
+		r.record(GameEvent{Type: CARD_VOTED, Player: eventPlayer(conn.Player), Card: cardIndex})
+
+		r.broadcastCards(context.Background())
 	} else {
-		r.Log.Warn(
-			fmt.Sprintf(
-				"(%s, %s) tried to vote for card at index %d but had already",
-				conn.Player.SessionID,
-				conn.Player.Name,
-				cardIndex,
-			),
-		)
+		r.reject(conn, REJECT_ALREADY_VOTED, fmt.Sprintf("tried to vote for card at index %d but had already", cardIndex))
 	}
 }
 
 func (r *Room) unvoteCard(cardIndex int, conn *connectionManager) {
-	r.GameStateMutex.Lock()
-	defer r.GameStateMutex.Unlock()
+	if r.Meeting != nil {
+		r.reject(conn, REJECT_MEETING_IN_PROGRESS, "tried to unvote a card during a meeting")
+		return
+	}
 
-	if r.Turn != SPY {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to select a card while it wasn't the Spies' go",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+	if r.Finished || r.Turn != SPY || r.awaitingTieBreak() {
+		r.reject(conn, REJECT_NOT_YOUR_TURN, "tried to unvote a card while it wasn't the Spies' go")
 		return
 	}
 
-	if conn.Player.Role != SPY && conn.Player.Role != COUNTERSPY {
-		r.Log.Error(
-			fmt.Sprintf(
-				"(%s, %s) tried to suggest clue but is not the Spymaster",
-				conn.Player.SessionID,
-				conn.Player.Name,
-			),
-		)
+	if (conn.Player.Role != SPY && conn.Player.Role != COUNTERSPY) || conn.Player.Ejected {
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to unvote a card but is not a Spy or Counterspy")
+		return
+	}
+
+	if r.TiedCards != nil && !slices.Contains(r.TiedCards, cardIndex) {
+		r.reject(conn, REJECT_NOT_TIED, fmt.Sprintf("tried to unvote card %d in a runoff it was not part of", cardIndex))
 		return
 	}
 
 	unvoted, err := r.Grid.UnvoteCardAtIndex(cardIndex, conn.Player.SessionID)
 	if err != nil {
-		r.Log.Error(err.Error())
+		r.rejectCardError(conn, err)
+		return
 	}
 
 	if unvoted {
@@ -422,65 +771,28 @@ func (r *Room) unvoteCard(cardIndex int, conn *connectionManager) {
 
 		conn.Player.Votes -= 1
 
-		// TODO(Matthew): broadcast to voter a card change to reflect accepted vote.
+		r.record(GameEvent{Type: CARD_UNVOTED, Player: eventPlayer(conn.Player), Card: cardIndex})
+
+		r.broadcastCards(context.Background())
 	} else {
-		r.Log.Warn(
-			fmt.Sprintf(
-				"(%s, %s) tried to unvote card at index %d but had not voted for it",
-				conn.Player.SessionID,
-				conn.Player.Name,
-				cardIndex,
-			),
-		)
+		r.reject(conn, REJECT_NOT_VOTED, fmt.Sprintf("tried to unvote card at index %d but had not voted for it", cardIndex))
 	}
 }
 
-func (r *Room) cookie(name string, value string) *http.Cookie {
+func (r *Room) cookie(name string, value string) (*http.Cookie, error) {
+	encoded, err := r.Cookies.Encode(name, value)
+	if err != nil {
+		return nil, err
+	}
+
 	// Set cookies regarding a room to expire after 36 hours, that would be a long game
 	// of susnames...
 	return &http.Cookie{
 		Name:     name,
-		Value:    value,
+		Value:    encoded,
 		Secure:   r.Config.GetBool("secure"),
 		HttpOnly: r.Config.GetBool("http_only"),
 		Expires:  time.Now().Add(36 * time.Hour),
 		Path:     "/room/" + r.Name,
-	}
-}
-
-func (r *Room) processCommand(comm *command, conn *connectionManager) {
-	switch comm.Cmd {
-	case "start-game":
-		r.startGame()
-	case "suggest-clue":
-		clueMatches, err := strconv.Atoi(comm.Data1)
-		if err != nil {
-			r.Log.Error(fmt.Sprintf("could not parse Data1 as integer (clue matches): %s", comm.Data1))
-			return
-		}
-
-		r.suggestClue(comm.Data0, clueMatches, conn)
-	case "vote-card":
-		cardIndex, err := strconv.Atoi(comm.Data0)
-		if err != nil {
-			r.Log.Error(fmt.Sprintf("could not parse Data0 as integer (card index): %s", comm.Data0))
-			return
-		}
-
-		r.voteCard(cardIndex, conn)
-	case "unvote-card":
-		cardIndex, err := strconv.Atoi(comm.Data0)
-		if err != nil {
-			r.Log.Error(fmt.Sprintf("could not parse Data0 as integer (card index): %s", comm.Data0))
-			return
-		}
-
-		r.unvoteCard(cardIndex, conn)
-	case "end-clue-guessing":
-		r.endClueGuessing(conn)
-	case "change-name":
-		r.setPlayerName(comm.Data0)
-	default:
-		r.Log.Error(fmt.Sprintf("unrecognised command: %s", comm.Cmd))
-	}
+	}, nil
 }
@@ -0,0 +1,250 @@
+package room
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MatthewJM96/susnames/deck"
+	"github.com/MatthewJM96/susnames/grid"
+	"github.com/MatthewJM96/susnames/session"
+	"github.com/spf13/viper"
+)
+
+/**
+ * A game played by calling the room's handlers directly, as would its event loop, with
+ * each player connected over a connection that merely queues the messages sent to it.
+ */
+type testGame struct {
+	t     *testing.T
+	room  *Room
+	conns map[string]*connectionManager // Connection of each player, by session ID.
+}
+
+func newTestRoom(t *testing.T) *Room {
+	t.Helper()
+
+	config := viper.New()
+	config.Set("default_deck", "english")
+	config.Set("vote_time", time.Minute)
+	config.Set("reconnect_grace_period", time.Minute)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	decks, err := deck.NewLibrary(config, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies, err := session.NewCookieCodec(config, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newRoom(config, log, decks, cookies, "test-room", "")
+}
+
+/**
+ * Starts a game between the given number of players, all of them claiming to be spies,
+ * after applying the given changes to the room's default settings. Unless changed, games
+ * are played without counterspies so that every spy votes in good faith.
+ */
+func startTestGame(t *testing.T, players int, configure func(*Settings)) *testGame {
+	t.Helper()
+
+	r := newTestRoom(t)
+	r.Settings.Counterspies = 0
+	if configure != nil {
+		configure(&r.Settings)
+	}
+
+	g := &testGame{t: t, room: r, conns: make(map[string]*connectionManager)}
+
+	for index := 0; index < players; index++ {
+		sessionID := fmt.Sprintf("session-%d", index)
+
+		player := newPlayer(index, sessionID, fmt.Sprintf("player-%d", index))
+		r.Players[sessionID] = player
+
+		// Connections aren't added to the player, so nothing is broadcast to them.
+		g.conns[sessionID] = &connectionManager{
+			SessionID: sessionID,
+			Room:      r,
+			Player:    player,
+			Format:    FORMAT_JSON,
+			Msgs:      make(chan []byte, 256),
+		}
+
+		if r.Host == "" {
+			r.Host = sessionID
+		}
+	}
+
+	t.Cleanup(
+		func() {
+			if r.VoteTimer != nil {
+				r.VoteTimer.Stop()
+			}
+		},
+	)
+
+	g.do(g.conns[r.Host], func(conn *connectionManager) { r.startGame(conn) })
+
+	return g
+}
+
+/**
+ * Adds a new player to the room, as when a session connects to it for the first time.
+ */
+func (g *testGame) join() *connectionManager {
+	sessionID := fmt.Sprintf("session-%d", len(g.conns))
+
+	conn := &connectionManager{
+		SessionID: sessionID,
+		Room:      g.room,
+		Format:    FORMAT_JSON,
+		Msgs:      make(chan []byte, 256),
+	}
+
+	player := g.room.addPlayer(sessionID, fmt.Sprintf("player-%d", len(g.conns)), conn)
+
+	// As with the other players, nothing is broadcast to the new player.
+	delete(player.Conns, conn)
+
+	g.conns[sessionID] = conn
+
+	return conn
+}
+
+/**
+ * Runs a command from the given connection, failing the test should it be rejected.
+ */
+func (g *testGame) do(conn *connectionManager, command func(*connectionManager)) {
+	g.t.Helper()
+
+	conn.Rejected = false
+	command(conn)
+
+	if conn.Rejected {
+		g.t.Fatalf("command from %s was rejected: %s", conn.Player.Name, <-conn.Msgs)
+	}
+}
+
+/**
+ * Runs a command from the given connection, failing the test should it not be rejected
+ * with the given code.
+ */
+func (g *testGame) reject(conn *connectionManager, code rejectionCode, command func(*connectionManager)) {
+	g.t.Helper()
+
+	conn.Rejected = false
+	command(conn)
+
+	if !conn.Rejected {
+		g.t.Fatalf("command from %s was not rejected", conn.Player.Name)
+	}
+
+	if message := string(<-conn.Msgs); !strings.Contains(message, string(code)) {
+		g.t.Fatalf("command from %s was rejected with %s, wanted %s", conn.Player.Name, message, code)
+	}
+}
+
+func (g *testGame) spymaster() *connectionManager {
+	g.t.Helper()
+
+	for _, conn := range g.conns {
+		if conn.Player.Role == SPYMASTER && g.room.Players[conn.SessionID] != nil {
+			return conn
+		}
+	}
+
+	g.t.Fatal("game has no spymaster")
+
+	return nil
+}
+
+/**
+ * Gets the connections of the spies yet in the game, in order of player ID.
+ */
+func (g *testGame) spies() []*connectionManager {
+	spies := make([]*connectionManager, 0)
+	for index := 0; index < len(g.conns); index++ {
+		conn := g.conns[fmt.Sprintf("session-%d", index)]
+		if conn.Player.Role == SPY && g.room.Players[conn.SessionID] != nil {
+			spies = append(spies, conn)
+		}
+	}
+
+	return spies
+}
+
+func (g *testGame) suggestClue(matches int) {
+	g.t.Helper()
+
+	g.do(g.spymaster(), func(conn *connectionManager) { g.room.suggestClue("clue", matches, conn) })
+}
+
+/**
+ * Gets the unselected cards of the given type, failing the test should there be fewer
+ * than the given count.
+ */
+func (g *testGame) cards(cardType grid.CardType, count int) []int {
+	g.t.Helper()
+
+	cards := g.room.Grid.Unselected(cardType)
+	if len(cards) < count {
+		g.t.Fatalf("wanted %d unselected cards of type %d, have %d", count, cardType, len(cards))
+	}
+
+	return cards[:count]
+}
+
+/**
+ * Casts a vote from the given spy for each of the given cards.
+ */
+func (g *testGame) vote(spy *connectionManager, cards ...int) {
+	g.t.Helper()
+
+	for _, card := range cards {
+		g.do(spy, func(conn *connectionManager) { g.room.voteCard(card, conn) })
+	}
+}
+
+/**
+ * Ends the current round of voting as would the vote timer running out.
+ */
+func (g *testGame) timeOut() {
+	g.room.VoteTimer.Stop()
+	g.room.endVotingOnTimeout(g.room.VoteRound)
+}
+
+/**
+ * Gets the cards selected so far in the game, in order of index.
+ */
+func (g *testGame) selected() []int {
+	selected := make([]int, 0)
+	for index, card := range g.room.Grid.Cards {
+		if card.Selected {
+			selected = append(selected, index)
+		}
+	}
+
+	return selected
+}
+
+/**
+ * Gets the events of the given type in the log of the ongoing game.
+ */
+func (g *testGame) events(eventType gameEventType) []GameEvent {
+	events := make([]GameEvent, 0)
+	for _, event := range g.room.Games[len(g.room.Games)-1].Events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
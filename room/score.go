@@ -0,0 +1,98 @@
+package room
+
+import (
+	"sort"
+
+	"github.com/MatthewJM96/susnames/components"
+)
+
+// Points given to every player on the winning side of a game.
+const SCORE_WIN = 3
+
+// Points given to each player who voted to eject a player of the other side, and taken
+// from each who voted to eject a player of their own side, should that player be ejected.
+const SCORE_EJECTION = 1
+
+/**
+ * Gets the side the player is on, the spymaster playing on the side of the spies.
+ * Spectators are on neither side.
+ */
+func playerSide(role PlayerRole) PlayerRole {
+	if role == SPY || role == SPYMASTER {
+		return SPY
+	}
+
+	return role
+}
+
+/**
+ * Scores a meeting's votes once it has ejected a player. Those who voted for the ejection
+ * are rewarded should the ejected player have been on the other side, and penalised
+ * should they have been on the same side.
+ */
+func (r *Room) scoreEjection(ejected *Player, votes map[string]string) {
+	for sessionID, target := range votes {
+		voter, exists := r.Players[sessionID]
+		if !exists || target != ejected.SessionID {
+			continue
+		}
+
+		if playerSide(voter.Role) == playerSide(ejected.Role) {
+			voter.Score -= SCORE_EJECTION
+		} else {
+			voter.Score += SCORE_EJECTION
+		}
+	}
+}
+
+/**
+ * Scores the game once it has been won, and records the win in the game's log along with
+ * the score of every player.
+ */
+func (r *Room) recordWin() {
+	for _, player := range r.Players {
+		if playerSide(player.Role) == r.Winner {
+			player.Score += SCORE_WIN
+		}
+	}
+
+	r.record(GameEvent{Type: GAME_WON, Winner: r.Winner, Scores: r.scores()})
+}
+
+/**
+ * Gets the scores of everyone who played in the game, highest first.
+ */
+func (r *Room) scores() []gameEventScore {
+	scores := make([]gameEventScore, 0, len(r.Players))
+	for _, player := range r.Players {
+		if player.Role == SPECTATOR {
+			continue
+		}
+
+		scores = append(scores, gameEventScore{*eventPlayer(player), player.Score})
+	}
+
+	sort.Slice(
+		scores,
+		func(i, j int) bool {
+			if scores[i].Score != scores[j].Score {
+				return scores[i].Score > scores[j].Score
+			}
+
+			return scores[i].Name < scores[j].Name
+		},
+	)
+
+	return scores
+}
+
+func (r *Room) makeScoreViews() []components.ScoreView {
+	scores := r.scores()
+
+	views := make([]components.ScoreView, 0, len(scores))
+	for _, score := range scores {
+		views = append(views, components.ScoreView{Name: score.Name, Role: getPlayerRoleClass(score.Role), Score: score.Score})
+	}
+
+	return views
+}
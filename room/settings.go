@@ -0,0 +1,253 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/MatthewJM96/susnames/components"
+	"github.com/spf13/viper"
+)
+
+// Value of a setting that is to be chosen automatically when the game starts.
+const AUTO = -1
+
+const MIN_VOTE_TIME = 10 * time.Second
+const MAX_VOTE_TIME = 5 * time.Minute
+
+/**
+ * Settings of a room that configure its games. Settings may only be changed between
+ * games, and those that may be chosen automatically hold AUTO until the game starts.
+ */
+type Settings struct {
+	Counterspies         int
+	EndVotingOn          int
+	SpyCards             int
+	CounterspyCards      int
+	CounterspyWinReveals int // Number of counterspy targets revealed to end game.
+	VoteTime             time.Duration
+	Deck                 string
+	TieBreak             tieBreakPolicy // How votes tied between cards are settled.
+	NoVotes              noVotesPolicy  // How rounds without any votes are settled.
+	Reveals              revealsPolicy  // How many cards are revealed each round.
+	Meetings             int            // Number of emergency meetings that may be called each game.
+}
+
+func defaultSettings(config *viper.Viper) Settings {
+	return Settings{
+		Counterspies:         AUTO,
+		EndVotingOn:          AUTO,
+		SpyCards:             12,
+		CounterspyCards:      6,
+		CounterspyWinReveals: AUTO,
+		VoteTime:             config.GetDuration("vote_time"),
+		Deck:                 config.GetString("default_deck"),
+		TieBreak:             TIE_RANDOM,
+		NoVotes:              NO_VOTES_SKIP,
+		Reveals:              REVEALS_ONE,
+		Meetings:             1,
+	}
+}
+
+/**
+ * Validates the settings against the number of players who would play were the game to
+ * start now, returning a reason for the settings being invalid if they are. Until there
+ * are enough players to start a game, settings aren't validated against the number of
+ * players, so that the host may set up the room while players are arriving.
+ */
+func (r *Room) validateSettings(settings Settings, players int) string {
+	if settings.SpyCards < 1 {
+		return "there must be at least one spy card"
+	}
+
+	if settings.CounterspyCards < 1 {
+		return "there must be at least one counterspy card"
+	}
+
+	if settings.SpyCards+settings.CounterspyCards > 25 {
+		return "there can be at most 25 spy and counterspy cards in total"
+	}
+
+	if settings.CounterspyWinReveals != AUTO &&
+		(settings.CounterspyWinReveals < 1 || settings.CounterspyWinReveals > settings.CounterspyCards) {
+		return "counterspy cards needed to win must be between one and the number of counterspy cards"
+	}
+
+	if settings.Counterspies != AUTO {
+		if settings.Counterspies < 0 {
+			return "there can't be a negative number of counterspies"
+		}
+
+		// One player is the spymaster, and counterspies must be fewer than loyal spies.
+		most := defaultCounterspies(players - 1)
+		if players >= MIN_PLAYERS && settings.Counterspies > most {
+			return fmt.Sprintf("with %d players there can be at most %d counterspies", players, most)
+		}
+	}
+
+	if settings.EndVotingOn != AUTO {
+		if settings.EndVotingOn < 1 {
+			return "at least one spy must be needed to end voting"
+		}
+
+		if players >= MIN_PLAYERS && settings.EndVotingOn > players-1 {
+			return fmt.Sprintf("with %d players at most %d spies can be needed to end voting", players, players-1)
+		}
+	}
+
+	if settings.VoteTime < MIN_VOTE_TIME || settings.VoteTime > MAX_VOTE_TIME {
+		return fmt.Sprintf("vote time must be between %s and %s", MIN_VOTE_TIME.String(), MAX_VOTE_TIME.String())
+	}
+
+	if settings.Meetings < 0 || settings.Meetings > MAX_MEETINGS {
+		return fmt.Sprintf("there can be between 0 and %d meetings each game", MAX_MEETINGS)
+	}
+
+	if !slices.Contains(tieBreakPolicies, settings.TieBreak) {
+		return fmt.Sprintf("no way to break ties exists named: %s", settings.TieBreak)
+	}
+
+	if !slices.Contains(noVotesPolicies, settings.NoVotes) {
+		return fmt.Sprintf("no way to settle rounds without votes exists named: %s", settings.NoVotes)
+	}
+
+	if !slices.Contains(revealsPolicies, settings.Reveals) {
+		return fmt.Sprintf("no way to reveal cards exists named: %s", settings.Reveals)
+	}
+
+	if r.findDeck(settings.Deck) == nil {
+		return fmt.Sprintf("no deck exists with name: %s", settings.Deck)
+	}
+
+	return ""
+}
+
+/**
+ * Counts the players who would play in a game were it to start now.
+ */
+func (r *Room) playingPlayerCount() int {
+	count := 0
+	for _, player := range r.Players {
+		if player.ClaimedRole != SPECTATOR {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+func (r *Room) updateSettings(settings Settings, conn *connectionManager) {
+	if r.Host != conn.SessionID {
+		r.reject(conn, REJECT_NOT_HOST, "tried to change settings but is not the host")
+		return
+	}
+
+	if r.inProgress() {
+		r.reject(conn, REJECT_GAME_IN_PROGRESS, "tried to change settings while a game is in progress")
+		return
+	}
+
+	reason := r.validateSettings(settings, r.playingPlayerCount())
+	if reason != "" {
+		r.rejectWithMessage(conn, REJECT_INVALID_SETTINGS, fmt.Sprintf("tried to apply invalid settings: %s", reason), "Invalid settings: "+reason+".")
+		return
+	}
+
+	r.Settings = settings
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) updated settings of room %s", conn.SessionID, conn.Player.Name, r.Name))
+
+	r.broadcastSettings(context.Background())
+}
+
+func formatAuto(value int) string {
+	if value == AUTO {
+		return ""
+	}
+
+	return strconv.Itoa(value)
+}
+
+func (r *Room) deckNames() []string {
+	names := make([]string, 0)
+	for _, deck := range r.Decks.List() {
+		names = append(names, deck.Name)
+	}
+
+	if r.CustomDeck != nil && r.Decks.Get(r.CustomDeck.Name) == nil {
+		names = append(names, r.CustomDeck.Name)
+	}
+
+	return names
+}
+
+func (r *Room) makeSettings(ctx context.Context, player *Player) []byte {
+	buf := new(bytes.Buffer)
+
+	components.Settings(
+		components.SettingsView{
+			Counterspies:         formatAuto(r.Settings.Counterspies),
+			EndVotingOn:          formatAuto(r.Settings.EndVotingOn),
+			SpyCards:             strconv.Itoa(r.Settings.SpyCards),
+			CounterspyCards:      strconv.Itoa(r.Settings.CounterspyCards),
+			CounterspyWinReveals: formatAuto(r.Settings.CounterspyWinReveals),
+			VoteTime:             strconv.Itoa(int(r.Settings.VoteTime.Seconds())),
+			Deck:                 r.Settings.Deck,
+			Decks:                r.deckNames(),
+			TieBreak:             string(r.Settings.TieBreak),
+			NoVotes:              string(r.Settings.NoVotes),
+			Reveals:              string(r.Settings.Reveals),
+			Meetings:             strconv.Itoa(r.Settings.Meetings),
+			Editable:             r.Host == player.SessionID && !r.inProgress(),
+		},
+	).Render(ctx, buf)
+
+	return buf.Bytes()
+}
+
+func (r *Room) makeSettingsJSON() []byte {
+	return r.marshalJSONMessage(
+		"settings",
+		"",
+		jsonSettings{
+			Counterspies:         r.Settings.Counterspies,
+			EndVotingOn:          r.Settings.EndVotingOn,
+			SpyCards:             r.Settings.SpyCards,
+			CounterspyCards:      r.Settings.CounterspyCards,
+			CounterspyWinReveals: r.Settings.CounterspyWinReveals,
+			VoteTime:             int(r.Settings.VoteTime.Seconds()),
+			Deck:                 r.Settings.Deck,
+			Decks:                r.deckNames(),
+			TieBreak:             string(r.Settings.TieBreak),
+			NoVotes:              string(r.Settings.NoVotes),
+			Reveals:              string(r.Settings.Reveals),
+			Meetings:             r.Settings.Meetings,
+		},
+	)
+}
+
+func (r *Room) broadcastSettings(ctx context.Context) {
+	r.changed()
+
+	r.broadcastMessage(
+		func(player *Player, format messageFormat) ([]byte, bool) {
+			if format == FORMAT_JSON {
+				return r.makeSettingsJSON(), false
+			}
+
+			return r.makeSettings(ctx, player), false
+		},
+	)
+}
+
+func (r *Room) sendSettingsToConnection(ctx context.Context, conn *connectionManager) {
+	if conn.Format == FORMAT_JSON {
+		conn.send(r.makeSettingsJSON())
+		return
+	}
+
+	conn.send(r.makeSettings(ctx, conn.Player))
+}
@@ -0,0 +1,446 @@
+package room
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MatthewJM96/susnames/deck"
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+// How long a room waits after changing before it is saved, that a burst of changes be saved together.
+const SNAPSHOT_DELAY = time.Second
+
+type playerSnapshot struct {
+	ID            int        `json:"id"`
+	SessionID     string     `json:"sessionID"`
+	Name          string     `json:"name"`
+	Role          PlayerRole `json:"role"`
+	ClaimedRole   PlayerRole `json:"claimedRole"`
+	Votes         int        `json:"votes"`
+	EndedGuessing bool       `json:"endedGuessing"`
+	Ejected       bool       `json:"ejected,omitempty"`
+	Score         int        `json:"score,omitempty"`
+}
+
+/**
+ * Everything about a room that should survive a restart of the server. Connections and
+ * timers don't survive, and are instead set up anew when the room is restored.
+ */
+type roomSnapshot struct {
+	Name       string     `json:"name"`
+	Host       string     `json:"host"`
+	Settings   Settings   `json:"settings"`
+	CustomDeck *deck.Deck `json:"customDeck,omitempty"`
+
+	Public      bool     `json:"public"`
+	Access      string   `json:"access"`
+	Passcode    []byte   `json:"passcode,omitempty"`
+	AccessEpoch int      `json:"accessEpoch"`
+	Admitted    []string `json:"admitted"`
+	Banned      []string `json:"banned"`
+
+	Players      []playerSnapshot `json:"players"`
+	NextPlayerID int              `json:"nextPlayerID"`
+
+	Started      bool       `json:"started"`
+	Finished     bool       `json:"finished"`
+	Winner       PlayerRole `json:"winner"`
+	Spies        int        `json:"spies"`
+	Counterspies int        `json:"counterspies"`
+	Turn         PlayerRole `json:"turn"`
+	Clue         string     `json:"clue"`
+	ClueMatches  int        `json:"clueMatches"`
+	Grid         *grid.Grid `json:"grid,omitempty"`
+	Games        []*GameLog `json:"games"` // Only the ongoing game, finished games being saved apart.
+	GamesStarted int        `json:"gamesStarted"`
+	VoteRound    int        `json:"voteRound"`
+	VoteEndVotes int        `json:"voteEndVotes"`
+	EndVotingOn  int        `json:"endVotingOn"`
+	TiedCards    []int      `json:"tiedCards,omitempty"`
+	Revealed     []int      `json:"revealed,omitempty"`
+
+	Meeting        *Meeting `json:"meeting,omitempty"`
+	MeetingsCalled int      `json:"meetingsCalled"`
+}
+
+func (r *Room) snapshot() *roomSnapshot {
+	snapshot := &roomSnapshot{
+		Name:         r.Name,
+		Host:         r.Host,
+		Settings:     r.Settings,
+		CustomDeck:   r.CustomDeck,
+		Public:       r.Public,
+		Access:       string(r.Access),
+		Passcode:     r.Passcode,
+		AccessEpoch:  r.AccessEpoch,
+		Admitted:     make([]string, 0, len(r.Admitted)),
+		Banned:       make([]string, 0, len(r.Banned)),
+		Players:      make([]playerSnapshot, 0, len(r.Players)),
+		NextPlayerID: r.NextPlayerID,
+		Started:      r.Started,
+		Finished:     r.Finished,
+		Winner:       r.Winner,
+		Spies:        r.Spies,
+		Counterspies: r.Counterspies,
+		Turn:         r.Turn,
+		Clue:         r.Clue,
+		ClueMatches:  r.ClueMatches,
+		Grid:         r.Grid,
+		Games:        make([]*GameLog, 0, 1),
+		GamesStarted: r.GamesStarted,
+		VoteRound:    r.VoteRound,
+		VoteEndVotes: r.VoteEndVotes,
+		EndVotingOn:  r.EndVotingOn,
+		TiedCards:    r.TiedCards,
+		Revealed:     r.Revealed,
+
+		Meeting:        r.Meeting,
+		MeetingsCalled: r.MeetingsCalled,
+	}
+
+	for _, game := range r.Games {
+		if !game.Finished {
+			snapshot.Games = append(snapshot.Games, game)
+		}
+	}
+
+	for sessionID := range r.Admitted {
+		snapshot.Admitted = append(snapshot.Admitted, sessionID)
+	}
+
+	for sessionID := range r.Banned {
+		snapshot.Banned = append(snapshot.Banned, sessionID)
+	}
+
+	for _, player := range r.Players {
+		snapshot.Players = append(
+			snapshot.Players,
+			playerSnapshot{
+				ID:            player.ID,
+				SessionID:     player.SessionID,
+				Name:          player.Name,
+				Role:          player.Role,
+				ClaimedRole:   player.ClaimedRole,
+				Votes:         player.Votes,
+				EndedGuessing: player.EndedGuessing,
+				Ejected:       player.Ejected,
+				Score:         player.Score,
+			},
+		)
+	}
+
+	// Keep snapshots of unchanged rooms identical, whatever order the maps were walked in.
+	sort.Strings(snapshot.Admitted)
+	sort.Strings(snapshot.Banned)
+	sort.Slice(
+		snapshot.Players,
+		func(i, j int) bool {
+			return snapshot.Players[i].ID < snapshot.Players[j].ID
+		},
+	)
+
+	return snapshot
+}
+
+/**
+ * Schedules the room to be saved to its store, should it have one, once SNAPSHOT_DELAY has
+ * passed. Runs after every event handled by the room, so that the room is saved but once
+ * for a burst of events.
+ */
+func (r *Room) schedulePersist() {
+	if r.writer == nil || r.Closed || r.persistTimer != nil {
+		return
+	}
+
+	r.persistTimer = time.AfterFunc(
+		SNAPSHOT_DELAY,
+		func() {
+			select {
+			case r.persistDue <- struct{}{}:
+			case <-r.done:
+			}
+		},
+	)
+}
+
+/**
+ * Saves a snapshot of the room to its store, if the room has one and has changed since it
+ * was last saved. The logs of finished games are saved only when a game has finished or
+ * been dropped since they were last saved. Snapshots are taken within the room's event
+ * loop, but are written to the store outside of it.
+ */
+func (r *Room) persist() {
+	if r.persistTimer != nil {
+		r.persistTimer.Stop()
+		r.persistTimer = nil
+	}
+
+	if r.writer == nil || r.Closed {
+		return
+	}
+
+	data, err := json.Marshal(r.snapshot())
+	if err != nil {
+		r.Log.Error(fmt.Sprintf("could not snapshot room %s: %s", r.Name, err.Error()))
+		return
+	}
+
+	var gamesData []byte
+
+	games := r.finishedGames()
+	if span := gameSpan(games); span != r.lastGamesSpan {
+		gamesData, err = json.Marshal(games)
+		if err != nil {
+			r.Log.Error(fmt.Sprintf("could not snapshot games of room %s: %s", r.Name, err.Error()))
+			return
+		}
+
+		r.lastGamesSpan = span
+	}
+
+	if gamesData == nil && bytes.Equal(data, r.lastSnapshot) {
+		return
+	}
+
+	r.writer.write(data, gamesData)
+
+	r.lastSnapshot = data
+}
+
+func (r *Room) finishedGames() []*GameLog {
+	games := make([]*GameLog, 0, len(r.Games))
+	for _, game := range r.Games {
+		if game.Finished {
+			games = append(games, game)
+		}
+	}
+
+	return games
+}
+
+/**
+ * Gets the IDs of the first and last of the games. As the logs of finished games never
+ * change, and games are only ever dropped from the front, two lists of finished games
+ * with the same span are the same.
+ */
+func gameSpan(games []*GameLog) [2]int {
+	if len(games) == 0 {
+		return [2]int{}
+	}
+
+	return [2]int{games[0].ID, games[len(games)-1].ID}
+}
+
+/**
+ * Restores the room from a snapshot. Every player starts out disconnected, with the
+ * usual grace period in which to reconnect to their seat, and any vote or meeting under
+ * way is given its full time again so that players may reconnect before it ends. Must be called before
+ * the room starts running.
+ */
+func (r *Room) restore(data []byte, gamesData []byte) error {
+	// Settings missing from snapshots of older versions keep their defaults.
+	snapshot := &roomSnapshot{Settings: r.Settings}
+
+	err := json.Unmarshal(data, snapshot)
+	if err != nil {
+		return fmt.Errorf("could not read snapshot of room %s: %w", r.Name, err)
+	}
+
+	games := make([]*GameLog, 0)
+	if gamesData != nil {
+		err = json.Unmarshal(gamesData, &games)
+		if err != nil {
+			return fmt.Errorf("could not read games of room %s: %w", r.Name, err)
+		}
+	}
+
+	r.Host = snapshot.Host
+	r.Settings = snapshot.Settings
+	r.CustomDeck = snapshot.CustomDeck
+	r.Public = snapshot.Public
+	r.Access = accessMode(snapshot.Access)
+	r.Passcode = snapshot.Passcode
+	r.AccessEpoch = snapshot.AccessEpoch
+	r.NextPlayerID = snapshot.NextPlayerID
+	r.Started = snapshot.Started
+	r.Finished = snapshot.Finished
+	r.Winner = snapshot.Winner
+	r.Spies = snapshot.Spies
+	r.Counterspies = snapshot.Counterspies
+	r.Turn = snapshot.Turn
+	r.Clue = snapshot.Clue
+	r.ClueMatches = snapshot.ClueMatches
+	r.Grid = snapshot.Grid
+	r.Games = games
+	r.lastGamesSpan = gameSpan(games)
+
+	// Snapshots of older versions held finished games too, and one may have been saved
+	// apart since the snapshot was taken.
+	for _, game := range snapshot.Games {
+		if len(games) == 0 || game.ID > games[len(games)-1].ID {
+			r.Games = append(r.Games, game)
+		}
+	}
+	r.GamesStarted = snapshot.GamesStarted
+	r.VoteRound = snapshot.VoteRound
+	r.VoteEndVotes = snapshot.VoteEndVotes
+	r.EndVotingOn = snapshot.EndVotingOn
+	r.TiedCards = snapshot.TiedCards
+	r.Revealed = snapshot.Revealed
+	r.Meeting = snapshot.Meeting
+	r.MeetingsCalled = snapshot.MeetingsCalled
+
+	if r.Started && r.Grid == nil {
+		return fmt.Errorf("snapshot of room %s has a game without a grid", r.Name)
+	}
+
+	for _, sessionID := range snapshot.Admitted {
+		r.Admitted[sessionID] = struct{}{}
+	}
+
+	for _, sessionID := range snapshot.Banned {
+		r.Banned[sessionID] = struct{}{}
+	}
+
+	gracePeriod := r.Config.GetDuration("reconnect_grace_period")
+
+	for _, saved := range snapshot.Players {
+		player := newPlayer(saved.ID, saved.SessionID, saved.Name)
+		player.Role = saved.Role
+		player.ClaimedRole = saved.ClaimedRole
+		player.Votes = saved.Votes
+		player.EndedGuessing = saved.EndedGuessing
+		player.Ejected = saved.Ejected
+		player.Score = saved.Score
+		player.DisconnectedAt = time.Now()
+
+		sessionID := saved.SessionID
+		player.DisconnectTimer = time.AfterFunc(
+			gracePeriod,
+			func() {
+				r.post(
+					func() {
+						r.removePlayer(sessionID)
+					},
+				)
+			},
+		)
+
+		r.Players[sessionID] = player
+	}
+
+	if r.inProgress() && r.Meeting != nil {
+		r.startMeetingTimer()
+	} else if r.inProgress() && r.Turn == SPY {
+		r.startVoteTimer()
+	}
+
+	r.lastSnapshot = data
+
+	return nil
+}
+
+/**
+ * Writes the snapshots of a room to its store, apart from the room's event loop so that
+ * the room need not wait on the store. Should snapshots be taken faster than they can be
+ * written, only the latest is written.
+ */
+type snapshotWriter struct {
+	store Store
+	name  string
+	log   *slog.Logger
+
+	pending      []byte
+	pendingGames []byte
+	mutex        sync.Mutex
+
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSnapshotWriter(store Store, name string, log *slog.Logger) *snapshotWriter {
+	return &snapshotWriter{
+		store: store,
+		name:  name,
+		log:   log,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+/**
+ * Queues the snapshot, and the logs of finished games should they have changed, to be
+ * written, replacing any snapshot yet to be written.
+ */
+func (w *snapshotWriter) write(snapshot []byte, games []byte) {
+	w.mutex.Lock()
+
+	w.pending = snapshot
+	if games != nil {
+		w.pendingGames = games
+	}
+
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+/**
+ * Writes queued snapshots until the writer is closed, so should be run in its own
+ * goroutine.
+ */
+func (w *snapshotWriter) run() {
+	defer close(w.done)
+
+	for range w.wake {
+		w.flush()
+	}
+
+	w.flush()
+}
+
+func (w *snapshotWriter) flush() {
+	w.mutex.Lock()
+
+	snapshot, games := w.pending, w.pendingGames
+	w.pending, w.pendingGames = nil, nil
+
+	w.mutex.Unlock()
+
+	if games != nil {
+		err := w.store.SaveGames(w.name, games)
+		if err != nil {
+			w.log.Error(err.Error())
+		}
+	}
+
+	if snapshot != nil {
+		err := w.store.Save(w.name, snapshot)
+		if err != nil {
+			w.log.Error(err.Error())
+		}
+	}
+}
+
+/**
+ * Closes the writer, waiting for any queued snapshot to be written.
+ */
+func (w *snapshotWriter) close() {
+	w.closeOnce.Do(
+		func() {
+			close(w.wake)
+		},
+	)
+
+	<-w.done
+}
@@ -0,0 +1,151 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/**
+ * Stores snapshots of rooms so that they may be restored should the server restart.
+ * Snapshots are opaque to stores, and are keyed by the name of the room. The logs of a
+ * room's finished games never change, and so are stored apart from its snapshots, to be
+ * saved only as games finish.
+ */
+type Store interface {
+	Save(name string, snapshot []byte) error
+	SaveGames(name string, games []byte) error
+	Delete(name string) error
+	LoadAll() (map[string][]byte, error)
+	LoadGames(name string) ([]byte, error)
+}
+
+/**
+ * Stores snapshots of rooms as files in a directory, one per room.
+ */
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create room store directory %s: %w", dir, err)
+	}
+
+	return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.Dir, name+".json")
+}
+
+func (s *FileStore) gamesPath(name string) string {
+	return filepath.Join(s.Dir, name+".games")
+}
+
+/**
+ * Writes the data to the path by way of a temporary file, so that a crash mid-write
+ * doesn't leave a corrupt file behind. The file, and the directory it is renamed within,
+ * are synced to disk before returning.
+ */
+func (s *FileStore) writeFile(path string, data []byte) error {
+	file, err := os.CreateTemp(s.Dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	dir, err := os.Open(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+func (s *FileStore) Save(name string, snapshot []byte) error {
+	err := s.writeFile(s.path(name), snapshot)
+	if err != nil {
+		return fmt.Errorf("could not save snapshot of room %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) SaveGames(name string, games []byte) error {
+	err := s.writeFile(s.gamesPath(name), games)
+	if err != nil {
+		return fmt.Errorf("could not save games of room %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) Delete(name string) error {
+	for _, path := range []string{s.path(name), s.gamesPath(name)} {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not delete snapshot of room %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) LoadAll() (map[string][]byte, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read room store directory %s: %w", s.Dir, err)
+	}
+
+	snapshots := make(map[string][]byte)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.Dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read snapshot %s: %w", entry.Name(), err)
+		}
+
+		snapshots[strings.TrimSuffix(entry.Name(), ".json")] = data
+	}
+
+	return snapshots, nil
+}
+
+/**
+ * Loads the logs of the room's finished games, giving nil should none have been saved.
+ */
+func (s *FileStore) LoadGames(name string) ([]byte, error) {
+	data, err := os.ReadFile(s.gamesPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read games of room %s: %w", name, err)
+	}
+
+	return data, nil
+}
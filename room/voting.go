@@ -0,0 +1,300 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/MatthewJM96/susnames/grid"
+	"github.com/MatthewJM96/susnames/util"
+)
+
+type tieBreakPolicy string
+
+const (
+	TIE_RANDOM    tieBreakPolicy = "random"    // One of the tied cards is revealed at random.
+	TIE_NONE      tieBreakPolicy = "none"      // No card is revealed, and the turn passes.
+	TIE_SPYMASTER tieBreakPolicy = "spymaster" // The spymaster chooses which tied card is revealed.
+	TIE_RUNOFF    tieBreakPolicy = "runoff"    // The spies vote again between the tied cards.
+)
+
+var tieBreakPolicies = []tieBreakPolicy{TIE_RANDOM, TIE_NONE, TIE_SPYMASTER, TIE_RUNOFF}
+
+type noVotesPolicy string
+
+const (
+	NO_VOTES_SKIP    noVotesPolicy = "skip"    // No card is revealed, and the turn passes.
+	NO_VOTES_PENALTY noVotesPolicy = "penalty" // A counterspy target is revealed at random.
+	NO_VOTES_RANDOM  noVotesPolicy = "random"  // Any card is revealed at random.
+)
+
+var noVotesPolicies = []noVotesPolicy{NO_VOTES_SKIP, NO_VOTES_PENALTY, NO_VOTES_RANDOM}
+
+type revealsPolicy string
+
+const (
+	REVEALS_ONE        revealsPolicy = "one"  // One card is revealed each round.
+	REVEALS_CLUE_COUNT revealsPolicy = "clue" // Up to as many cards as the clue's count are revealed.
+)
+
+var revealsPolicies = []revealsPolicy{REVEALS_ONE, REVEALS_CLUE_COUNT}
+
+/**
+ * Reasons for a card being revealed, as given in the game log.
+ */
+const (
+	REVEAL_VOTE             = "vote"
+	REVEAL_TIE_RANDOM       = "tie-random"
+	REVEAL_TIE_SPYMASTER    = "tie-spymaster"
+	REVEAL_NO_VOTES_PENALTY = "no-votes-penalty"
+	REVEAL_NO_VOTES_RANDOM  = "no-votes-random"
+)
+
+/**
+ * Reports whether voting is paused for the spymaster to break a tie.
+ */
+func (r *Room) awaitingTieBreak() bool {
+	return r.TiedCards != nil && r.Settings.TieBreak == TIE_SPYMASTER
+}
+
+/**
+ * Gets the number of votes each spy may cast in the current round of voting. In a
+ * runoff between tied cards each spy has but one vote.
+ */
+func (r *Room) voteLimit() int {
+	if r.TiedCards != nil {
+		return 1
+	}
+
+	return r.ClueMatches + 1
+}
+
+/**
+ * Opens a new round of voting, clearing the votes of any previous round.
+ */
+func (r *Room) openVoting() {
+	r.VoteEndVotes = 0
+	r.Grid.ResetVote()
+
+	for _, player := range r.Players {
+		player.Votes = 0
+		player.EndedGuessing = false
+	}
+
+	r.VoteRound += 1
+
+	r.startVoteTimer()
+}
+
+/**
+ * Opens a runoff vote between the tied cards. Only the votes for the tied cards are
+ * cleared, so that the votes for other cards still count should more cards be revealed
+ * this round.
+ */
+func (r *Room) openRunoff(tiedCards []int) {
+	r.TiedCards = tiedCards
+
+	r.VoteEndVotes = 0
+	r.Grid.ResetVotesAt(tiedCards)
+
+	for _, player := range r.Players {
+		player.Votes = 0
+		player.EndedGuessing = false
+	}
+
+	r.VoteRound += 1
+
+	r.startVoteTimer()
+}
+
+func (r *Room) revealCard(cardIndex int, reason string) {
+	err := r.Grid.SelectCardAtIndex(cardIndex)
+	if err != nil {
+		r.Log.Error(fmt.Sprintf("could not reveal card in room %s: %s", r.Name, err.Error()))
+		return
+	}
+
+	r.Revealed = append(r.Revealed, cardIndex)
+
+	r.record(GameEvent{Type: CARD_REVEALED, Card: cardIndex, Outcome: reason})
+
+	r.Log.Info(fmt.Sprintf("revealed card at index %d in room %s by %s", cardIndex, r.Name, reason))
+}
+
+func (r *Room) revealRandomCard(cardIndices []int, reason string) {
+	if len(cardIndices) == 0 {
+		return
+	}
+
+	util.RefreshRandSeed()
+
+	r.revealCard(cardIndices[util.Rnd.Intn(len(cardIndices))], reason)
+}
+
+/**
+ * Settles the vote for the next card to be revealed this round. During a runoff only
+ * the tied cards are considered, and should no one vote in it, the cards remain tied.
+ * Returns true if voting continues until a tie is broken.
+ */
+func (r *Room) settleVote() bool {
+	mostVoted := r.Grid.MostVoted(r.TiedCards...)
+	if len(mostVoted) == 0 && r.TiedCards != nil {
+		mostVoted = r.TiedCards
+	}
+
+	if len(mostVoted) > 1 {
+		return r.resolveTie(mostVoted)
+	}
+
+	r.TiedCards = nil
+
+	if len(mostVoted) == 1 {
+		r.revealCard(mostVoted[0], REVEAL_VOTE)
+	} else if len(r.Revealed) == 0 {
+		r.resolveNoVotes()
+	}
+
+	return false
+}
+
+/**
+ * Reports whether another card is to be revealed this round. Should the room reveal as
+ * many cards as the clue's count, cards continue to be revealed in order of votes until
+ * one that isn't a spy target is revealed, or the spies have found all of their targets.
+ */
+func (r *Room) revealsAnother() bool {
+	if r.Settings.Reveals != REVEALS_CLUE_COUNT || len(r.Revealed) == 0 {
+		return false
+	}
+
+	if len(r.Revealed) >= max(r.ClueMatches, 1) {
+		return false
+	}
+
+	if r.Grid.Cards[r.Revealed[len(r.Revealed)-1]].Type != grid.SPY_TARGET {
+		return false
+	}
+
+	if len(r.Grid.Unselected(grid.SPY_TARGET)) == 0 {
+		return false
+	}
+
+	return len(r.Grid.MostVoted()) > 0
+}
+
+/**
+ * Reveals any further cards due to be revealed this round, then ends the round unless
+ * voting continues until a tie is broken.
+ */
+func (r *Room) continueReveals() {
+	for r.revealsAnother() {
+		revealed := len(r.Revealed)
+
+		if r.settleVote() {
+			return
+		}
+
+		// A tie may have been settled without revealing a card.
+		if len(r.Revealed) == revealed {
+			break
+		}
+	}
+
+	r.endVotingRound()
+}
+
+/**
+ * Settles a round of voting in which no card received a vote, as set by the room's
+ * settings. Should the spies be penalised when no counterspy targets remain, the turn
+ * simply passes.
+ */
+func (r *Room) resolveNoVotes() {
+	r.Log.Info(fmt.Sprintf("no card received a vote in room %s", r.Name))
+
+	if r.Settings.NoVotes == NO_VOTES_RANDOM {
+		r.revealRandomCard(r.Grid.Unselected(), REVEAL_NO_VOTES_RANDOM)
+		return
+	}
+
+	targets := r.Grid.Unselected(grid.COUNTERSPY_TARGET)
+	if r.Settings.NoVotes == NO_VOTES_PENALTY && len(targets) > 0 {
+		r.revealRandomCard(targets, REVEAL_NO_VOTES_PENALTY)
+		return
+	}
+
+	r.record(GameEvent{Type: NO_CARD_VOTED})
+}
+
+/**
+ * Settles a tie between cards for the most votes, as set by the room's settings. Returns
+ * true if voting continues until the tie is broken, and false otherwise. A runoff that
+ * ties again is settled by chance.
+ */
+func (r *Room) resolveTie(tiedCards []int) bool {
+	policy := r.Settings.TieBreak
+	if policy == TIE_RUNOFF && r.TiedCards != nil {
+		policy = TIE_RANDOM
+	}
+
+	r.Log.Info(fmt.Sprintf("vote tied between cards %v in room %s, resolving by %s", tiedCards, r.Name, policy))
+
+	r.record(GameEvent{Type: VOTE_TIED, Cards: tiedCards, Outcome: string(policy)})
+
+	r.TiedCards = nil
+
+	switch policy {
+	case TIE_NONE:
+		return false
+	case TIE_SPYMASTER:
+		r.TiedCards = tiedCards
+
+		// Should the spymaster not choose in time, the tie is settled by chance.
+		r.VoteRound += 1
+		r.startVoteTimer()
+	case TIE_RUNOFF:
+		r.openRunoff(tiedCards)
+	default:
+		r.revealRandomCard(tiedCards, REVEAL_TIE_RANDOM)
+		return false
+	}
+
+	r.broadcastGameState(context.Background())
+
+	return true
+}
+
+/**
+ * Reveals the tied card chosen by the spymaster, should the room's settings have the
+ * spymaster break ties.
+ */
+func (r *Room) breakTie(cardIndex int, conn *connectionManager) {
+	if r.Meeting != nil {
+		r.reject(conn, REJECT_MEETING_IN_PROGRESS, "tried to break a tie during a meeting")
+		return
+	}
+
+	if r.Finished || !r.awaitingTieBreak() {
+		r.reject(conn, REJECT_NOT_YOUR_TURN, "tried to break a tie while there was none to break")
+		return
+	}
+
+	if conn.Player.Role != SPYMASTER {
+		r.reject(conn, REJECT_WRONG_ROLE, "tried to break a tie but is not the Spymaster")
+		return
+	}
+
+	if !slices.Contains(r.TiedCards, cardIndex) {
+		r.reject(conn, REJECT_NOT_TIED, fmt.Sprintf("tried to break a tie with card %d which was not tied", cardIndex))
+		return
+	}
+
+	r.VoteTimer.Stop()
+
+	r.Log.Info(fmt.Sprintf("(%s, %s) broke tie with card at index %d", conn.SessionID, conn.Player.Name, cardIndex))
+
+	r.TiedCards = nil
+
+	r.revealCard(cardIndex, REVEAL_TIE_SPYMASTER)
+
+	r.continueReveals()
+}
@@ -0,0 +1,510 @@
+package room
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/MatthewJM96/susnames/grid"
+)
+
+/**
+ * Starts a game with the given tie-break policy, and has two spies vote for a different
+ * civilian card each, so that the cards tie once voting ends.
+ */
+func startTiedGame(t *testing.T, policy tieBreakPolicy) (*testGame, []int) {
+	t.Helper()
+
+	g := startTestGame(
+		t,
+		4,
+		func(settings *Settings) {
+			settings.TieBreak = policy
+		},
+	)
+
+	tied := g.cards(grid.CIVILIAN, 2)
+
+	g.suggestClue(1)
+
+	spies := g.spies()
+	g.vote(spies[0], tied[0])
+	g.vote(spies[1], tied[1])
+
+	return g, tied
+}
+
+/**
+ * Checks that one of the given cards, and no other, has been revealed.
+ */
+func assertRevealedOneOf(t *testing.T, g *testGame, cards []int) {
+	t.Helper()
+
+	selected := g.selected()
+	if len(selected) != 1 || !slices.Contains(cards, selected[0]) {
+		t.Errorf("revealed cards %v, wanted one of %v", selected, cards)
+	}
+}
+
+func assertTurn(t *testing.T, g *testGame, turn PlayerRole) {
+	t.Helper()
+
+	if g.room.Turn != turn {
+		t.Errorf("turn is %s, wanted %s", getPlayerRoleClass(g.room.Turn), getPlayerRoleClass(turn))
+	}
+}
+
+/**
+ * Checks how each vote tie in the game was settled, in order.
+ */
+func assertTieOutcomes(t *testing.T, g *testGame, outcomes ...tieBreakPolicy) {
+	t.Helper()
+
+	ties := g.events(VOTE_TIED)
+	if len(ties) != len(outcomes) {
+		t.Fatalf("logged %d ties, wanted %d", len(ties), len(outcomes))
+	}
+
+	for index, tie := range ties {
+		if tie.Outcome != string(outcomes[index]) {
+			t.Errorf("tie %d settled by %s, wanted %s", index, tie.Outcome, outcomes[index])
+		}
+	}
+}
+
+func TestTieBreakRandom(t *testing.T) {
+	g, tied := startTiedGame(t, TIE_RANDOM)
+
+	g.timeOut()
+
+	assertRevealedOneOf(t, g, tied)
+	assertTurn(t, g, SPYMASTER)
+	assertTieOutcomes(t, g, TIE_RANDOM)
+
+	if reveals := g.events(CARD_REVEALED); len(reveals) != 1 || reveals[0].Outcome != REVEAL_TIE_RANDOM {
+		t.Errorf("logged reveals %v, wanted one by %s", reveals, REVEAL_TIE_RANDOM)
+	}
+}
+
+func TestTieBreakNone(t *testing.T) {
+	g, _ := startTiedGame(t, TIE_NONE)
+
+	g.timeOut()
+
+	if selected := g.selected(); len(selected) != 0 {
+		t.Errorf("revealed cards %v, wanted none", selected)
+	}
+
+	assertTurn(t, g, SPYMASTER)
+	assertTieOutcomes(t, g, TIE_NONE)
+}
+
+func TestTieBreakSpymaster(t *testing.T) {
+	g, tied := startTiedGame(t, TIE_SPYMASTER)
+	r := g.room
+
+	g.timeOut()
+
+	if !r.awaitingTieBreak() || !slices.Equal(r.TiedCards, tied) {
+		t.Fatalf("awaiting tie break between %v, wanted %v", r.TiedCards, tied)
+	}
+
+	assertTurn(t, g, SPY)
+	assertTieOutcomes(t, g, TIE_SPYMASTER)
+
+	// Spies may neither vote nor break the tie while the spymaster chooses.
+	spy := g.spies()[0]
+	g.reject(spy, REJECT_NOT_YOUR_TURN, func(conn *connectionManager) { r.voteCard(tied[0], conn) })
+	g.reject(spy, REJECT_WRONG_ROLE, func(conn *connectionManager) { r.breakTie(tied[0], conn) })
+
+	spymaster := g.spymaster()
+	untied := g.cards(grid.SPY_TARGET, 1)[0]
+	g.reject(spymaster, REJECT_NOT_TIED, func(conn *connectionManager) { r.breakTie(untied, conn) })
+
+	g.do(spymaster, func(conn *connectionManager) { r.breakTie(tied[1], conn) })
+
+	if selected := g.selected(); !slices.Equal(selected, tied[1:]) {
+		t.Errorf("revealed cards %v, wanted %v", selected, tied[1:])
+	}
+
+	assertTurn(t, g, SPYMASTER)
+
+	if r.TiedCards != nil {
+		t.Errorf("cards %v still tied once the tie was broken", r.TiedCards)
+	}
+}
+
+func TestTieBreakSpymasterTimeout(t *testing.T) {
+	g, tied := startTiedGame(t, TIE_SPYMASTER)
+
+	g.timeOut()
+
+	// The spymaster doesn't choose in time, and so the tie is settled by chance.
+	g.timeOut()
+
+	assertRevealedOneOf(t, g, tied)
+	assertTurn(t, g, SPYMASTER)
+
+	if g.room.TiedCards != nil {
+		t.Errorf("cards %v still tied once the tie was settled", g.room.TiedCards)
+	}
+}
+
+func TestTieBreakRunoff(t *testing.T) {
+	g, tied := startTiedGame(t, TIE_RUNOFF)
+	r := g.room
+
+	untied := g.cards(grid.SPY_TARGET, 1)[0]
+
+	spies := g.spies()
+	g.vote(spies[0], untied)
+	g.vote(spies[2], tied...)
+
+	// The spy target has one vote to the civilians' two each, so the civilians tie.
+	g.timeOut()
+
+	if !slices.Equal(r.TiedCards, tied) {
+		t.Fatalf("runoff between cards %v, wanted %v", r.TiedCards, tied)
+	}
+
+	assertTurn(t, g, SPY)
+	assertTieOutcomes(t, g, TIE_RUNOFF)
+
+	for _, card := range tied {
+		if votes := len(r.Grid.Cards[card].Votes); votes != 0 {
+			t.Errorf("tied card %d kept %d votes into the runoff", card, votes)
+		}
+	}
+
+	if votes := len(r.Grid.Cards[untied].Votes); votes != 1 {
+		t.Errorf("untied card %d has %d votes in the runoff, wanted 1", untied, votes)
+	}
+
+	// Each spy has but one vote, and only for the tied cards.
+	spy := spies[0]
+	g.reject(spy, REJECT_NOT_TIED, func(conn *connectionManager) { r.voteCard(untied, conn) })
+	g.vote(spy, tied[1])
+	g.reject(spy, REJECT_OUT_OF_VOTES, func(conn *connectionManager) { r.voteCard(tied[0], conn) })
+
+	g.timeOut()
+
+	if selected := g.selected(); !slices.Equal(selected, tied[1:]) {
+		t.Errorf("revealed cards %v, wanted %v", selected, tied[1:])
+	}
+
+	assertTurn(t, g, SPYMASTER)
+}
+
+func TestTieBreakRunoffSettledByChance(t *testing.T) {
+	tests := []struct {
+		name  string
+		votes [][]int // Index into the tied cards of each spy's vote in the runoff.
+	}{
+		{
+			name:  "runoff ties again",
+			votes: [][]int{{0}, {1}},
+		},
+		{
+			name:  "no one votes in runoff",
+			votes: [][]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				g, tied := startTiedGame(t, TIE_RUNOFF)
+
+				g.timeOut()
+
+				spies := g.spies()
+				for index, votes := range test.votes {
+					for _, vote := range votes {
+						g.vote(spies[index], tied[vote])
+					}
+				}
+
+				g.timeOut()
+
+				assertRevealedOneOf(t, g, tied)
+				assertTurn(t, g, SPYMASTER)
+				assertTieOutcomes(t, g, TIE_RUNOFF, TIE_RANDOM)
+			},
+		)
+	}
+}
+
+func TestNoVotes(t *testing.T) {
+	tests := []struct {
+		policy   noVotesPolicy
+		revealed int
+		types    []grid.CardType // Types the revealed card may be of, any should none be given.
+		reason   string
+	}{
+		{
+			policy:   NO_VOTES_SKIP,
+			revealed: 0,
+		},
+		{
+			policy:   NO_VOTES_PENALTY,
+			revealed: 1,
+			types:    []grid.CardType{grid.COUNTERSPY_TARGET},
+			reason:   REVEAL_NO_VOTES_PENALTY,
+		},
+		{
+			policy:   NO_VOTES_RANDOM,
+			revealed: 1,
+			reason:   REVEAL_NO_VOTES_RANDOM,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(
+			string(test.policy),
+			func(t *testing.T) {
+				g := startTestGame(
+					t,
+					4,
+					func(settings *Settings) {
+						settings.NoVotes = test.policy
+					},
+				)
+
+				g.suggestClue(1)
+				g.timeOut()
+
+				assertTurn(t, g, SPYMASTER)
+
+				selected := g.selected()
+				if len(selected) != test.revealed {
+					t.Fatalf("revealed cards %v, wanted %d", selected, test.revealed)
+				}
+
+				if test.revealed == 0 {
+					if len(g.events(NO_CARD_VOTED)) != 1 {
+						t.Error("round without votes was not logged")
+					}
+
+					return
+				}
+
+				if cardType := g.room.Grid.Cards[selected[0]].Type; len(test.types) > 0 && !slices.Contains(test.types, cardType) {
+					t.Errorf("revealed card of type %d, wanted one of %v", cardType, test.types)
+				}
+
+				if reveals := g.events(CARD_REVEALED); reveals[0].Outcome != test.reason {
+					t.Errorf("card revealed by %s, wanted %s", reveals[0].Outcome, test.reason)
+				}
+			},
+		)
+	}
+}
+
+/**
+ * Gets the cards revealed so far in the game, in the order they were revealed.
+ */
+func revealOrder(g *testGame) []int {
+	order := make([]int, 0)
+	for _, event := range g.events(CARD_REVEALED) {
+		order = append(order, event.Card)
+	}
+
+	return order
+}
+
+/**
+ * Starts a game with the given reveals policy, picking out three spy targets and a
+ * civilian card, named as in the votes of the tests.
+ */
+func startRevealsGame(t *testing.T, reveals revealsPolicy, tieBreak tieBreakPolicy) (*testGame, map[string]int) {
+	t.Helper()
+
+	g := startTestGame(
+		t,
+		4,
+		func(settings *Settings) {
+			settings.Reveals = reveals
+			settings.TieBreak = tieBreak
+		},
+	)
+
+	targets := g.cards(grid.SPY_TARGET, 3)
+
+	cards := map[string]int{
+		"target-0": targets[0],
+		"target-1": targets[1],
+		"target-2": targets[2],
+		"civilian": g.cards(grid.CIVILIAN, 1)[0],
+	}
+
+	return g, cards
+}
+
+func TestReveals(t *testing.T) {
+	tests := []struct {
+		name    string
+		reveals revealsPolicy
+		clue    int
+		votes   [][]string // Cards each spy votes for.
+		want    []string   // Cards revealed, in order.
+	}{
+		{
+			name:    "one card each round",
+			reveals: REVEALS_ONE,
+			clue:    3,
+			votes:   [][]string{{"target-0", "target-1"}, {"target-0", "target-1"}, {"target-0"}},
+			want:    []string{"target-0"},
+		},
+		{
+			name:    "in order of votes",
+			reveals: REVEALS_CLUE_COUNT,
+			clue:    3,
+			votes:   [][]string{{"target-0", "target-1", "target-2"}, {"target-0", "target-1"}, {"target-0"}},
+			want:    []string{"target-0", "target-1", "target-2"},
+		},
+		{
+			name:    "up to clue count",
+			reveals: REVEALS_CLUE_COUNT,
+			clue:    2,
+			votes:   [][]string{{"target-0", "target-1", "target-2"}, {"target-0", "target-1"}, {"target-0"}},
+			want:    []string{"target-0", "target-1"},
+		},
+		{
+			name:    "clue count of zero reveals one",
+			reveals: REVEALS_CLUE_COUNT,
+			clue:    0,
+			votes:   [][]string{{"target-0"}, {"target-0"}, {"target-1"}},
+			want:    []string{"target-0"},
+		},
+		{
+			name:    "stops at civilian",
+			reveals: REVEALS_CLUE_COUNT,
+			clue:    3,
+			votes:   [][]string{{"target-0", "civilian"}, {"target-0", "civilian", "target-1"}, {"civilian"}},
+			want:    []string{"civilian"},
+		},
+		{
+			name:    "civilian ends chain",
+			reveals: REVEALS_CLUE_COUNT,
+			clue:    3,
+			votes:   [][]string{{"target-0", "civilian", "target-1"}, {"target-0", "civilian"}, {"target-0"}},
+			want:    []string{"target-0", "civilian"},
+		},
+		{
+			name:    "stops once votes run out",
+			reveals: REVEALS_CLUE_COUNT,
+			clue:    3,
+			votes:   [][]string{{"target-0"}, {"target-0"}, {}},
+			want:    []string{"target-0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(
+			test.name,
+			func(t *testing.T) {
+				g, cards := startRevealsGame(t, test.reveals, TIE_RANDOM)
+
+				g.suggestClue(test.clue)
+
+				spies := g.spies()
+				for index, votes := range test.votes {
+					for _, vote := range votes {
+						g.vote(spies[index], cards[vote])
+					}
+				}
+
+				g.timeOut()
+
+				want := make([]int, 0, len(test.want))
+				for _, name := range test.want {
+					want = append(want, cards[name])
+				}
+
+				if order := revealOrder(g); !slices.Equal(order, want) {
+					t.Errorf("revealed cards %v, wanted %v", order, want)
+				}
+
+				assertTurn(t, g, SPYMASTER)
+
+				if g.room.Revealed != nil {
+					t.Errorf("cards %v still revealed this round once it ended", g.room.Revealed)
+				}
+			},
+		)
+	}
+}
+
+func TestRevealsRunoffMidRound(t *testing.T) {
+	g, cards := startRevealsGame(t, REVEALS_CLUE_COUNT, TIE_RUNOFF)
+	r := g.room
+
+	g.suggestClue(3)
+
+	spies := g.spies()
+	g.vote(spies[0], cards["target-0"], cards["target-1"], cards["target-2"])
+	g.vote(spies[1], cards["target-0"], cards["target-1"], cards["civilian"])
+	g.vote(spies[2], cards["target-0"], cards["target-2"])
+
+	// The first card is revealed before the next two tie, and go to a runoff.
+	g.timeOut()
+
+	tied := []int{cards["target-1"], cards["target-2"]}
+	slices.Sort(tied)
+
+	if !slices.Equal(r.TiedCards, tied) {
+		t.Fatalf("runoff between cards %v, wanted %v", r.TiedCards, tied)
+	}
+
+	if order := revealOrder(g); !slices.Equal(order, []int{cards["target-0"]}) {
+		t.Fatalf("revealed cards %v before the runoff, wanted %v", order, []int{cards["target-0"]})
+	}
+
+	assertTurn(t, g, SPY)
+
+	// Votes for the civilian card are kept, and so it is revealed after the runoff.
+	g.vote(spies[0], cards["target-2"])
+	g.timeOut()
+
+	want := []int{cards["target-0"], cards["target-2"], cards["civilian"]}
+	if order := revealOrder(g); !slices.Equal(order, want) {
+		t.Errorf("revealed cards %v, wanted %v", order, want)
+	}
+
+	assertTurn(t, g, SPYMASTER)
+}
+
+func TestRevealsSpymasterTieBreakMidRound(t *testing.T) {
+	g, cards := startRevealsGame(t, REVEALS_CLUE_COUNT, TIE_SPYMASTER)
+	r := g.room
+
+	g.suggestClue(3)
+
+	spies := g.spies()
+	g.vote(spies[0], cards["target-0"], cards["target-1"], cards["civilian"])
+	g.vote(spies[1], cards["target-0"], cards["target-1"], cards["target-2"])
+	g.vote(spies[2], cards["target-0"], cards["target-2"], cards["civilian"])
+
+	// The first card is revealed before the remaining three tie.
+	g.timeOut()
+
+	if !r.awaitingTieBreak() || len(r.TiedCards) != 3 {
+		t.Fatalf("awaiting tie break between %v, wanted three cards", r.TiedCards)
+	}
+
+	// Once the spymaster breaks the tie, the round goes on to settle the rest of the vote.
+	g.do(g.spymaster(), func(conn *connectionManager) { r.breakTie(cards["target-1"], conn) })
+
+	if !r.awaitingTieBreak() || len(r.TiedCards) != 2 {
+		t.Fatalf("awaiting tie break between %v, wanted two cards", r.TiedCards)
+	}
+
+	g.do(g.spymaster(), func(conn *connectionManager) { r.breakTie(cards["target-2"], conn) })
+
+	want := []int{cards["target-0"], cards["target-1"], cards["target-2"]}
+	if order := revealOrder(g); !slices.Equal(order, want) {
+		t.Errorf("revealed cards %v, wanted %v", order, want)
+	}
+
+	assertTurn(t, g, SPYMASTER)
+}
@@ -0,0 +1,188 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var ErrInvalidCookie = errors.New("cookie is invalid or has been tampered with")
+
+type cookieKey struct {
+	sign    []byte
+	encrypt cipher.AEAD
+}
+
+/**
+ * Encodes and decodes cookie values such that they cannot be forged by clients. Values
+ * are signed with an HMAC, and optionally encrypted, using the first of a list of keys.
+ * Any key in the list is accepted when decoding, so that keys can be rotated by putting
+ * a new key at the front of the list and retiring the old key once its cookies expire.
+ */
+type CookieCodec struct {
+	keys    []cookieKey
+	encrypt bool
+}
+
+/**
+ * Creates a cookie codec from the comma-separated secrets of the "session_keys" config
+ * option. If no secrets are configured, a random one is generated, meaning sessions will
+ * not survive a restart of the server.
+ */
+func NewCookieCodec(config *viper.Viper, log *slog.Logger) (*CookieCodec, error) {
+	secrets := make([]string, 0)
+	for _, secret := range strings.Split(config.GetString("session_keys"), ",") {
+		secret = strings.TrimSpace(secret)
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	if len(secrets) == 0 {
+		log.Warn("no session keys configured, generating a temporary key")
+
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate session key: %w", err)
+		}
+
+		secrets = append(secrets, string(secret))
+	}
+
+	codec := &CookieCodec{
+		keys:    make([]cookieKey, 0, len(secrets)),
+		encrypt: config.GetBool("session_encrypt"),
+	}
+
+	for _, secret := range secrets {
+		key, err := deriveCookieKey(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		codec.keys = append(codec.keys, key)
+	}
+
+	return codec, nil
+}
+
+/**
+ * Derives separate signing and encryption keys from a secret, so that the same bytes are
+ * never used for both purposes.
+ */
+func deriveCookieKey(secret string) (cookieKey, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("susnames-cookie-sign"))
+	sign := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("susnames-cookie-encrypt"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return cookieKey{}, fmt.Errorf("could not create cookie cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return cookieKey{}, fmt.Errorf("could not create cookie cipher: %w", err)
+	}
+
+	return cookieKey{sign: sign, encrypt: aead}, nil
+}
+
+func signature(key cookieKey, name string, payload string) []byte {
+	mac := hmac.New(sha256.New, key.sign)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+/**
+ * Encodes the value of the named cookie, along with the time of encoding. The name is
+ * bound into the signature so that a value cannot be moved from one cookie to another.
+ */
+func (c *CookieCodec) Encode(name string, value string) (string, error) {
+	key := c.keys[0]
+
+	plain := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(plain, uint64(time.Now().Unix()))
+	plain = append(plain, value...)
+
+	if c.encrypt {
+		nonce := make([]byte, key.encrypt.NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return "", fmt.Errorf("could not generate cookie nonce: %w", err)
+		}
+
+		plain = key.encrypt.Seal(nonce, nonce, plain, []byte(name))
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(plain)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature(key, name, payload)), nil
+}
+
+/**
+ * Decodes the value of the named cookie, returning also the time at which it was
+ * encoded. Returns ErrInvalidCookie if the value was not encoded by any of our keys.
+ */
+func (c *CookieCodec) Decode(name string, encoded string) (string, time.Time, error) {
+	payload, encodedSig, found := strings.Cut(encoded, ".")
+	if !found {
+		return "", time.Time{}, ErrInvalidCookie
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidCookie
+	}
+
+	plain, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidCookie
+	}
+
+	for _, key := range c.keys {
+		if !hmac.Equal(sig, signature(key, name, payload)) {
+			continue
+		}
+
+		if c.encrypt {
+			nonceSize := key.encrypt.NonceSize()
+			if len(plain) < nonceSize {
+				return "", time.Time{}, ErrInvalidCookie
+			}
+
+			plain, err = key.encrypt.Open(nil, plain[:nonceSize], plain[nonceSize:], []byte(name))
+			if err != nil {
+				return "", time.Time{}, ErrInvalidCookie
+			}
+		}
+
+		if len(plain) < 8 {
+			return "", time.Time{}, ErrInvalidCookie
+		}
+
+		issued := time.Unix(int64(binary.BigEndian.Uint64(plain[:8])), 0)
+
+		return string(plain[8:]), issued, nil
+	}
+
+	return "", time.Time{}, ErrInvalidCookie
+}
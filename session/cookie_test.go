@@ -0,0 +1,127 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func newTestCodec(t *testing.T, keys string, encrypt bool) *CookieCodec {
+	config := viper.New()
+	config.Set("session_keys", keys)
+	config.Set("session_encrypt", encrypt)
+
+	codec, err := NewCookieCodec(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("could not create cookie codec: %v", err)
+	}
+
+	return codec
+}
+
+func TestCookieCodecRoundTrip(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		codec := newTestCodec(t, "first-secret", encrypt)
+
+		for _, value := range []string{"", "session-id", "with.dots and spaces"} {
+			encoded, err := codec.Encode("SN-Session", value)
+			if err != nil {
+				t.Fatalf("could not encode %q: %v", value, err)
+			}
+
+			decoded, issued, err := codec.Decode("SN-Session", encoded)
+			if err != nil {
+				t.Fatalf("could not decode %q (encrypted: %t): %v", value, encrypt, err)
+			}
+
+			if decoded != value {
+				t.Errorf("expected %q, decoded %q", value, decoded)
+			}
+
+			if time.Since(issued) > time.Minute {
+				t.Errorf("expected cookie to have just been issued, was issued at %s", issued)
+			}
+		}
+	}
+}
+
+func TestCookieCodecRejects(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		codec := newTestCodec(t, "first-secret", encrypt)
+
+		encoded, _ := codec.Encode("SN-Session", "session-id")
+
+		tests := []struct {
+			name    string
+			cookie  string
+			encoded string
+		}{
+			{"other cookie name", "SN-Invite", encoded},
+			{"tampered payload", "SN-Session", flipAt(encoded, 0)},
+			{"tampered signature", "SN-Session", flipAt(encoded, len(encoded)-2)},
+			{"no signature", "SN-Session", encoded[:len(encoded)-44]},
+			{"garbage", "SN-Session", "not a cookie"},
+			{"other key", "SN-Session", mustEncode(t, newTestCodec(t, "other-secret", encrypt), "session-id")},
+		}
+
+		for _, test := range tests {
+			_, _, err := codec.Decode(test.cookie, test.encoded)
+			if !errors.Is(err, ErrInvalidCookie) {
+				t.Errorf("%s (encrypted: %t): expected ErrInvalidCookie, got %v", test.name, encrypt, err)
+			}
+		}
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		old := newTestCodec(t, "old-secret", encrypt)
+		rotated := newTestCodec(t, "new-secret, old-secret", encrypt)
+		retired := newTestCodec(t, "new-secret", encrypt)
+
+		encoded := mustEncode(t, old, "session-id")
+
+		value, _, err := rotated.Decode("SN-Session", encoded)
+		if err != nil || value != "session-id" {
+			t.Errorf("expected cookie of old key to be accepted after rotation, got %q, %v", value, err)
+		}
+
+		// New cookies are encoded with the new key, and so outlive the old key.
+		encoded = mustEncode(t, rotated, "session-id")
+
+		value, _, err = retired.Decode("SN-Session", encoded)
+		if err != nil || value != "session-id" {
+			t.Errorf("expected cookie of new key to be accepted once old key is retired, got %q, %v", value, err)
+		}
+
+		_, _, err = retired.Decode("SN-Session", mustEncode(t, old, "session-id"))
+		if !errors.Is(err, ErrInvalidCookie) {
+			t.Errorf("expected cookie of retired key to be rejected, got %v", err)
+		}
+	}
+}
+
+func mustEncode(t *testing.T, codec *CookieCodec, value string) string {
+	encoded, err := codec.Encode("SN-Session", value)
+	if err != nil {
+		t.Fatalf("could not encode %q: %v", value, err)
+	}
+
+	return encoded
+}
+
+/**
+ * Changes the character at the index to another that is still valid base64.
+ */
+func flipAt(encoded string, index int) string {
+	flipped := byte('A')
+	if encoded[index] == 'A' {
+		flipped = 'B'
+	}
+
+	return encoded[:index] + string(flipped) + encoded[index+1:]
+}
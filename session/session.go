@@ -1,6 +1,9 @@
 package session
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -10,11 +13,15 @@ import (
 
 type SessionMiddlewareOpts func(*SessionMiddleware)
 
-var sessionID string = ""
+type contextKey struct{}
 
-func NewSessionMiddleware(next http.Handler, config *viper.Viper) http.Handler {
+var sessionIDKey = contextKey{}
+
+func NewSessionMiddleware(next http.Handler, config *viper.Viper, log *slog.Logger, cookies *CookieCodec) http.Handler {
 	return SessionMiddleware{
 		Next:     next,
+		Log:      log,
+		Cookies:  cookies,
 		Secure:   config.GetBool("secure"),
 		HTTPOnly: config.GetBool("http_only"),
 	}
@@ -34,40 +41,80 @@ func WithHTTPOnly(httpOnly bool) SessionMiddlewareOpts {
 
 type SessionMiddleware struct {
 	Next     http.Handler
+	Log      *slog.Logger
+	Cookies  *CookieCodec
 	Secure   bool
 	HTTPOnly bool
 }
 
-func SessionID() string {
+/**
+ * Gets the session ID carried by the context of a request that has passed through the
+ * session middleware, or an empty string if there is none.
+ */
+func SessionID(ctx context.Context) string {
+	sessionID, ok := ctx.Value(sessionIDKey).(string)
+	if !ok {
+		return ""
+	}
+
 	return sessionID
 }
 
-func (mw SessionMiddleware) setSessionID(writer http.ResponseWriter) {
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+func (mw SessionMiddleware) setSessionID(writer http.ResponseWriter, sessionID string) error {
+	value, err := mw.Cookies.Encode("SN-SessionID", sessionID)
+	if err != nil {
+		return err
+	}
+
 	http.SetCookie(
 		writer,
 		&http.Cookie{
 			Name:     "SN-SessionID",
-			Value:    sessionID,
+			Value:    value,
 			Secure:   mw.Secure,
 			HttpOnly: mw.HTTPOnly,
 			Expires:  time.Now().Add(30 * 24 * time.Hour),
 			Path:     "/",
 		},
 	)
+
+	return nil
 }
 
 func (mw SessionMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var sessionID string
+	var issued time.Time
+
+	/**
+	 * Take the session from the cookie only if it was signed by us, otherwise start a
+	 * fresh session. Cookies are reissued as they near their expiry.
+	 */
+
 	cookie, err := request.Cookie("SN-SessionID")
 	if err == nil {
-		sessionID = cookie.Value
-
-		if cookie.Expires.Compare(time.Now().Add(5*24*time.Hour)) == -1 {
-			mw.setSessionID(writer)
+		sessionID, issued, err = mw.Cookies.Decode("SN-SessionID", cookie.Value)
+		if err != nil {
+			mw.Log.Warn(fmt.Sprintf("rejected session cookie: %s", err.Error()))
 		}
-	} else {
+	}
+
+	if err != nil {
 		sessionID = ksuid.New().String()
-		mw.setSessionID(writer)
+		issued = time.Time{}
+	}
+
+	if time.Since(issued) > 25*24*time.Hour {
+		err = mw.setSessionID(writer, sessionID)
+		if err != nil {
+			mw.Log.Error(err.Error())
+			http.Error(writer, "could not create session", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	mw.Next.ServeHTTP(writer, request)
+	mw.Next.ServeHTTP(writer, request.WithContext(WithSessionID(request.Context(), sessionID)))
 }
@@ -2,11 +2,48 @@ package util
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
-var Rnd *rand.Rand = rand.New(rand.NewSource(42))
+/**
+ * A source of random numbers that is safe for concurrent use, as every room and handler
+ * draws from the same generator.
+ */
+type lockedSource struct {
+	mutex  sync.Mutex
+	source rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.source.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.source.Seed(seed)
+}
+
+var source = &lockedSource{source: rand.NewSource(42).(rand.Source64)}
+
+var Rnd *rand.Rand = rand.New(source)
 
+/**
+ * Reseeds the generator. The source is reseeded directly, rather than through Rnd, as
+ * seeding a rand.Rand is not itself safe for concurrent use.
+ */
 func RefreshRandSeed() {
-	Rnd.Seed(time.Now().UTC().UnixNano())
+	source.Seed(time.Now().UTC().UnixNano())
 }